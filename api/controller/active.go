@@ -0,0 +1,178 @@
+//
+// File: active.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"oset/component/active"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/Dizzrt/go-sse"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultActivePushInterval = 5 * time.Second
+)
+
+// activeWindows are the windows active devices are counted over, by name
+var activeWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"30m", active.MaxWindow},
+}
+
+var (
+	activeStop chan struct{}
+
+	// activeStreams counts the open active device streams of each app
+	activeMu      sync.Mutex
+	activeStreams = make(map[int]int)
+)
+
+// recordActive marks the devices of reported events as seen.
+func recordActive(events []model.Event) {
+	if activeStop == nil || len(events) == 0 {
+		return
+	}
+
+	if err := active.Touch(context.Background(), db.Redis(), events); err != nil {
+		etlog.L().Warn("failed to track active devices", zap.Int("size", len(events)), zap.Error(err))
+	}
+}
+
+func countActive(ctx context.Context, aid int) (map[string]int64, error) {
+	windows := make([]time.Duration, len(activeWindows))
+	for i := range activeWindows {
+		windows[i] = activeWindows[i].window
+	}
+
+	counts, err := active.Count(ctx, db.Redis(), aid, windows)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]int64, len(counts))
+	for i := range counts {
+		res[activeWindows[i].name] = counts[i]
+	}
+	return res, nil
+}
+
+func activeChannel(aid int) string {
+	return fmt.Sprintf("/event/tool/active/%d", aid)
+}
+
+// runActivePusher pushes the active devices of every app with an open stream.
+func runActivePusher(stop chan struct{}) {
+	interval := viper.GetDuration("active.push_interval")
+	if interval <= 0 {
+		interval = defaultActivePushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		activeMu.Lock()
+		aids := make([]int, 0, len(activeStreams))
+		for aid := range activeStreams {
+			aids = append(aids, aid)
+		}
+		activeMu.Unlock()
+
+		for _, aid := range aids {
+			counts, err := countActive(context.Background(), aid)
+			if err != nil {
+				etlog.L().Warn("failed to count active devices", zap.Int("aid", aid), zap.Error(err))
+				continue
+			}
+
+			msg, _ := json.Marshal(counts)
+			sseServer.SendMessage(activeChannel(aid), sse.SimpleMessage(string(msg)))
+		}
+	}
+}
+
+func GetActiveDevices(ctx *gin.Context) {
+	if activeStop == nil {
+		abortCtx(ctx, http.StatusServiceUnavailable, "active devices are not tracked")
+		return
+	}
+
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get active devices failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	counts, err := countActive(ctx, aid)
+	if err != nil {
+		etlog.L().Error("failed to count active devices", zap.Int("aid", aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":    "success",
+		"active": counts,
+	})
+}
+
+// RegisterActiveStream serves a stream of the active devices of an app,
+// pushed every active.push_interval.
+func RegisterActiveStream(ctx *gin.Context) {
+	said := ctx.Param("aid")
+	aid, err := strconv.Atoi(said)
+	if err != nil || activeStop == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg": "invalid aid or active devices are not tracked",
+		})
+		ctx.Abort()
+
+		etlog.L().Warn("unable to register active device stream", zap.String("target_aid", said))
+		return
+	}
+
+	activeMu.Lock()
+	activeStreams[aid]++
+	activeMu.Unlock()
+
+	defer func() {
+		activeMu.Lock()
+		if activeStreams[aid]--; activeStreams[aid] <= 0 {
+			delete(activeStreams, aid)
+		}
+		activeMu.Unlock()
+	}()
+
+	etlog.L().Info("registerd active device stream", zap.Int("aid", aid))
+	sseServer.ServeHTTP(ctx.Writer, ctx.Request)
+}
@@ -0,0 +1,453 @@
+//
+// File: alert.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common"
+	"oset/component/alert"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAlertInterval = time.Minute
+	alertEventListLimit  = 100
+
+	// notifications are delivered by workers of their own, so that a slow
+	// webhook does not hold up the evaluation of the rules
+	alertDeliveryWorkers = 4
+	alertQueueSize       = 256
+	alertDeliveryTimeout = 30 * time.Second
+)
+
+var (
+	alertStop  chan struct{}
+	alertQueue chan *alertDelivery
+)
+
+// alertDelivery is a change of state of a rule waiting for its notification
+// to be delivered, event is saved once it has been.
+type alertDelivery struct {
+	webhook      string
+	notification alert.Notification
+	event        model.AlertEvent
+}
+
+// startAlerts starts the alert scheduler. Rules are evaluated against the
+// event counters, so stats must be enabled as well.
+func startAlerts() {
+	if !viper.GetBool("alert.enabled") {
+		return
+	}
+
+	if statsConfig == nil {
+		etlog.L().Warn("alerts are not evaluated, because stats are not enabled")
+		return
+	}
+
+	alertStop = make(chan struct{})
+	alertQueue = make(chan *alertDelivery, alertQueueSize)
+	for i := 0; i < alertDeliveryWorkers; i++ {
+		go runAlertDelivery(alertStop, alertQueue)
+	}
+	go runAlertScheduler(alertStop)
+}
+
+// runAlertDelivery delivers queued notifications until stopped, those still
+// queued then are not delivered.
+func runAlertDelivery(stop chan struct{}, queue chan *alertDelivery) {
+	for {
+		select {
+		case <-stop:
+			return
+		case d := <-queue:
+			deliverAlert(d)
+		}
+	}
+}
+
+func deliverAlert(d *alertDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), alertDeliveryTimeout)
+	err := alert.Notify(ctx, d.webhook, &d.notification)
+	cancel()
+
+	d.event.Notified = err == nil
+	if err != nil {
+		etlog.L().Error("failed to deliver alert", zap.Int("id", d.event.RuleID), zap.String("state", d.event.State), zap.Error(err))
+	}
+	saveAlertEvent(&d.event, err)
+}
+
+// saveAlertEvent records a change of state and why its notification failed.
+func saveAlertEvent(event *model.AlertEvent, err error) {
+	if err != nil {
+		event.Error = err.Error()
+		if len(event.Error) > 255 {
+			event.Error = event.Error[:255]
+		}
+	}
+
+	if res := db.Mysql().Create(event); res.Error != nil {
+		etlog.L().Error("failed to save alert event", zap.Int("id", event.RuleID), zap.Error(res.Error))
+	}
+}
+
+func runAlertScheduler(stop chan struct{}) {
+	interval := viper.GetDuration("alert.interval")
+	if interval <= 0 {
+		interval = defaultAlertInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var rules []model.AlertRule
+		res := db.Mysql().Where("enabled = ?", true).Find(&rules)
+		if res.Error != nil {
+			etlog.L().Error("failed to load alert rules", zap.Error(res.Error))
+			continue
+		}
+
+		now := time.Now()
+		for i := range rules {
+			evaluateAlert(&rules[i], now)
+		}
+	}
+}
+
+// evaluateAlert evaluates a rule and queues the notification of a change of
+// its state. When several instances see the same change only the one that
+// records it first notifies it.
+func evaluateAlert(rule *model.AlertRule, now time.Time) {
+	ctx := context.Background()
+	result, err := alert.Evaluate(ctx, db.Redis(), statsConfig, rule, now)
+	if err != nil {
+		etlog.L().Warn("failed to evaluate alert rule", zap.Int("id", rule.ID), zap.Int("aid", rule.Aid), zap.Error(err))
+		return
+	}
+
+	state := rule.State
+	next := state
+	if result.Known {
+		next = model.ALERT_STATE_OK
+		if result.Firing {
+			next = model.ALERT_STATE_FIRING
+		}
+	}
+
+	updates := map[string]interface{}{
+		"last_value":   result.Value,
+		"evaluated_at": now.Unix(),
+	}
+
+	if next == state || (state == "" && next == model.ALERT_STATE_OK) {
+		res := db.Mysql().Model(&model.AlertRule{}).Where("id = ?", rule.ID).UpdateColumns(updates)
+		if res.Error != nil {
+			etlog.L().Warn("failed to save alert rule evaluation", zap.Int("id", rule.ID), zap.Error(res.Error))
+		}
+		return
+	}
+
+	updates["state"] = next
+	updates["changed_at"] = now.Unix()
+	res := db.Mysql().Model(&model.AlertRule{}).Where("id = ? AND state = ?", rule.ID, state).UpdateColumns(updates)
+	if res.Error != nil {
+		etlog.L().Error("failed to save alert rule state", zap.Int("id", rule.ID), zap.String("state", next), zap.Error(res.Error))
+		return
+	} else if res.RowsAffected == 0 {
+		return
+	}
+
+	notifyState := next
+	if next == model.ALERT_STATE_OK {
+		notifyState = "resolved"
+	}
+
+	d := &alertDelivery{
+		webhook: rule.WebhookURL,
+		notification: alert.Notification{
+			RuleID:   rule.ID,
+			Aid:      rule.Aid,
+			Name:     rule.Name,
+			Event:    rule.Event,
+			Kind:     rule.Kind,
+			State:    notifyState,
+			Value:    result.Value,
+			Expected: result.Expected,
+			Window:   rule.Window,
+			Time:     now.Unix(),
+		},
+		event: model.AlertEvent{
+			RuleID:   rule.ID,
+			Aid:      rule.Aid,
+			State:    notifyState,
+			Value:    result.Value,
+			Expected: result.Expected,
+		},
+	}
+
+	select {
+	case alertQueue <- d:
+	default:
+		etlog.L().Error("failed to queue alert, the delivery queue is full", zap.Int("id", rule.ID), zap.String("state", notifyState))
+		saveAlertEvent(&d.event, errors.New("the delivery queue is full"))
+	}
+
+	etlog.L().Info("alert rule changed state", zap.Int("id", rule.ID), zap.Int("aid", rule.Aid), zap.String("state", notifyState), zap.Float64("value", result.Value), zap.Float64("expected", result.Expected))
+}
+
+func GetAlertRuleList(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get alert rule list failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	var ruleList []model.AlertRule
+	res := db.Mysql().Where("aid = ?", aid).Order("id").Find(&ruleList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get alert rule list", zap.Int("aid", aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(ruleList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":       "success",
+		"rule_list": string(jsonBytes),
+	})
+}
+
+func CreateAlertRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	rule := model.AlertRule{Enabled: true}
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to create alert rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "create alert rule failed")
+		return
+	}
+
+	if err = alert.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var app model.App
+	res := db.Mysql().Where("aid = ?", rule.Aid).First(&app)
+	if res.Error != nil {
+		etlog.L().Error("create alert rule failed", zap.Int("aid", rule.Aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the app does not exist")
+		return
+	}
+
+	rule.ID = 0
+	rule.State = model.ALERT_STATE_OK
+	rule.LastValue, rule.EvaluatedAt, rule.ChangedAt = 0, 0, 0
+	res = db.Mysql().Create(&rule)
+	if res.Error != nil {
+		etlog.L().Error("unable to create alert rule", zap.Int("aid", rule.Aid), zap.String("name", rule.Name), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "failed to create alert rule, "+res.Error.Error())
+		return
+	}
+
+	etlog.L().Info("created alert rule", zap.Int("aid", rule.Aid), zap.String("name", rule.Name), zap.String("event", rule.Event), zap.String("kind", rule.Kind), zap.Int("operator_uid", requestUser.Uid))
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+		"id":   rule.ID,
+	})
+}
+
+func UpdateAlertRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	// a rule stays enabled unless the update says otherwise
+	rule := model.AlertRule{Enabled: true}
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to update alert rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update alert rule failed")
+		return
+	}
+
+	if err = alert.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var origin model.AlertRule
+	res := db.Mysql().Where("id = ?", rule.ID).First(&origin)
+	if res.Error != nil {
+		etlog.L().Error("update alert rule failed", zap.Int("id", rule.ID), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the alert rule does not exist")
+		return
+	}
+
+	updates := map[string]interface{}{
+		"name":           rule.Name,
+		"event":          rule.Event,
+		"window":         rule.Window,
+		"kind":           rule.Kind,
+		"direction":      rule.Direction,
+		"threshold":      rule.Threshold,
+		"deviation":      rule.Deviation,
+		"baseline_weeks": rule.BaselineWeeks,
+		"min_baseline":   rule.MinBaseline,
+		"webhook_url":    rule.WebhookURL,
+		"enabled":        rule.Enabled,
+		"description":    rule.Description,
+	}
+
+	// the state of the old definition says nothing about the new one, reset it
+	// so that the next change is notified
+	if rule.Event != origin.Event || rule.Window != origin.Window || rule.Kind != origin.Kind ||
+		rule.Direction != origin.Direction || rule.Threshold != origin.Threshold || rule.Deviation != origin.Deviation ||
+		rule.BaselineWeeks != origin.BaselineWeeks || rule.MinBaseline != origin.MinBaseline {
+		updates["state"] = model.ALERT_STATE_OK
+		updates["last_value"] = 0
+		updates["changed_at"] = time.Now().Unix()
+	}
+
+	res = db.Mysql().Model(&model.AlertRule{}).Where("id = ?", rule.ID).Updates(updates)
+	if res.Error != nil {
+		etlog.L().Error("update alert rule failed", zap.Int("id", rule.ID), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+func DropAlertRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		etlog.L().Error("delete alert rule failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var rule model.AlertRule
+	res := db.Mysql().Where("id = ?", id).First(&rule)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			abortCtx(ctx, http.StatusOK, "the alert rule does not exist")
+			return
+		}
+
+		etlog.L().Error("delete alert rule failed", zap.Int("id", id), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	res = db.Mysql().Delete(&model.AlertRule{}, id)
+	if res.Error != nil {
+		etlog.L().Error("delete alert rule failed", zap.Int("id", id), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+// GetAlertEventList returns the latest state changes of a rule, or of every
+// rule of an app.
+func GetAlertEventList(ctx *gin.Context) {
+	query := db.Mysql()
+	if sid := ctx.Query("rule_id"); sid != "" {
+		id, err := strconv.Atoi(sid)
+		if err != nil {
+			abortCtx(ctx, http.StatusBadRequest, "invalid rule_id")
+			return
+		}
+		query = query.Where("rule_id = ?", id)
+	} else {
+		aid, err := strconv.Atoi(ctx.Query("aid"))
+		if err != nil {
+			etlog.L().Error("get alert event list failed", zap.Error(err))
+			abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+			return
+		}
+		query = query.Where("aid = ?", aid)
+	}
+
+	var eventList []model.AlertEvent
+	res := query.Order("id desc").Limit(alertEventListLimit).Find(&eventList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get alert event list", zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(eventList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":        "success",
+		"event_list": string(jsonBytes),
+	})
+}
@@ -0,0 +1,254 @@
+//
+// File: analysis.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/component/store"
+	"oset/db"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFunnelWindow = 24 * time.Hour
+	defaultPathDepth    = 3
+	defaultPathTopN     = 10
+)
+
+type retentionRequest struct {
+	Aid         int        `json:"aid"`
+	Start       store.Step `json:"start"`
+	Return      store.Step `json:"return"`
+	Granularity string     `json:"granularity"`
+	Mode        string     `json:"mode"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	Periods     int        `json:"periods"`
+}
+
+type pathRequest struct {
+	Aid        int        `json:"aid"`
+	Anchor     store.Step `json:"anchor"`
+	Direction  string     `json:"direction"`
+	Depth      int        `json:"depth"`
+	Top        int        `json:"top"`
+	SessionGap string     `json:"session_gap"`
+	Exclude    []string   `json:"exclude"`
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+}
+
+type funnelRequest struct {
+	Aid    int          `json:"aid"`
+	Steps  []store.Step `json:"steps"`
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Window string       `json:"window"`
+}
+
+// analysisLocation is the timezone days and weeks of an analysis start in.
+func analysisLocation() (*time.Location, error) {
+	name := viper.GetString("analysis.timezone")
+	if name == "" {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// abortAnalysis answers a failed analysis of the event store.
+func abortAnalysis(ctx *gin.Context, aid int, err error) {
+	if errors.Is(err, store.ErrDisabled) {
+		abortCtx(ctx, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	etlog.L().Error("failed to analyze events", zap.String("path", ctx.FullPath()), zap.Int("aid", aid), zap.Error(err))
+	abortCtx(ctx, http.StatusInternalServerError, "analysis failed")
+}
+
+func GetFunnel(ctx *gin.Context) {
+	var req funnelRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		etlog.L().Warn("unable to compute funnel, because bindjson failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid funnel")
+		return
+	}
+
+	window := defaultFunnelWindow
+	if req.Window != "" {
+		window, err = time.ParseDuration(req.Window)
+		if err != nil {
+			abortCtx(ctx, http.StatusBadRequest, "invalid window")
+			return
+		}
+	}
+
+	f := store.Funnel{
+		Aid:    req.Aid,
+		Steps:  req.Steps,
+		From:   req.From,
+		To:     req.To,
+		Window: window,
+	}
+
+	if err = f.Check(); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	steps, err := store.RunFunnel(ctx, &f)
+	if err != nil {
+		abortAnalysis(ctx, f.Aid, err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(steps)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":   "success",
+		"steps": string(jsonBytes),
+	})
+}
+
+func GetRetention(ctx *gin.Context) {
+	var req retentionRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		etlog.L().Warn("unable to compute retention, because bindjson failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid retention")
+		return
+	}
+
+	loc, err := analysisLocation()
+	if err != nil {
+		etlog.L().Error("invalid analysis timezone", zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "invalid analysis timezone")
+		return
+	}
+
+	r := store.Retention{
+		Aid:         req.Aid,
+		Start:       req.Start,
+		Return:      req.Return,
+		Granularity: req.Granularity,
+		Mode:        req.Mode,
+		From:        req.From,
+		To:          req.To,
+		Periods:     req.Periods,
+		Location:    loc,
+	}
+	if r.Granularity == "" {
+		r.Granularity = store.RetentionDay
+	}
+	if r.Mode == "" {
+		r.Mode = store.RetentionBounded
+	}
+
+	if err = r.Check(); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cohorts, err := store.RunRetention(ctx, db.Redis(), &r)
+	if err != nil {
+		abortAnalysis(ctx, r.Aid, err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(cohorts)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"cohorts": string(jsonBytes),
+	})
+}
+
+func GetPath(ctx *gin.Context) {
+	var req pathRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		etlog.L().Warn("unable to compute path, because bindjson failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid path")
+		return
+	}
+
+	// paths end where the sessions of the proxy would
+	gap := sessionGap()
+	if req.SessionGap != "" {
+		gap, err = time.ParseDuration(req.SessionGap)
+		if err != nil {
+			abortCtx(ctx, http.StatusBadRequest, "invalid session gap")
+			return
+		}
+	}
+
+	p := store.Path{
+		Aid:        req.Aid,
+		Anchor:     req.Anchor,
+		Direction:  req.Direction,
+		Depth:      req.Depth,
+		TopN:       req.Top,
+		SessionGap: gap,
+		Exclude:    req.Exclude,
+		From:       req.From,
+		To:         req.To,
+	}
+	if p.Direction == "" {
+		p.Direction = store.PathAfter
+	}
+	if p.Depth == 0 {
+		p.Depth = defaultPathDepth
+	}
+	if p.TopN == 0 {
+		p.TopN = defaultPathTopN
+	}
+
+	if err = p.Check(); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res, err := store.RunPath(ctx, &p)
+	if err != nil {
+		abortAnalysis(ctx, p.Aid, err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(res)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":  "success",
+		"path": string(jsonBytes),
+	})
+}
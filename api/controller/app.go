@@ -10,11 +10,13 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"oset/auth"
 	"oset/common"
+	"oset/component/redaction"
 	"oset/db"
 	"time"
 
@@ -23,6 +25,7 @@
 
 	"github.com/Dizzrt/etlog"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -62,6 +65,7 @@ func CreateApp(ctx *gin.Context) {
 	if newApp.Icon == "" {
 		newApp.Icon = viper.GetString("sys.self_host") + "/static/stream/defaultIcon.png"
 	}
+	newApp.RedactionSalt = redaction.NewSalt()
 
 	res := db.Mysql().Create(&newApp)
 	if res.Error != nil {
@@ -89,10 +93,14 @@ func UpdateApp(ctx *gin.Context) {
 	ctx.BindJSON(&newAppInfo)
 
 	res := db.Mysql().Model(&model.App{}).Where("aid = ?", newAppInfo.Aid).Updates(map[string]interface{}{
-		"icon":        newAppInfo.Icon,
-		"name":        newAppInfo.Name,
-		"activated":   newAppInfo.Activated,
-		"description": newAppInfo.Description,
+		"icon":         newAppInfo.Icon,
+		"name":         newAppInfo.Name,
+		"activated":    newAppInfo.Activated,
+		"description":  newAppInfo.Description,
+		"schema_mode":  newAppInfo.SchemaMode,
+		"clock_policy": newAppInfo.ClockPolicy,
+		"enrich_geo":   newAppInfo.EnrichGeo,
+		"enrich_ua":    newAppInfo.EnrichUA,
 	})
 
 	if res.Error != nil {
@@ -105,6 +113,7 @@ func UpdateApp(ctx *gin.Context) {
 		ctx.Abort()
 		return
 	}
+	invalidateApp(newAppInfo.Aid)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"code": common.StatusCommonOK,
@@ -137,6 +146,7 @@ func DropApp(ctx *gin.Context) {
 		ctx.Abort()
 		return
 	}
+	invalidateApp(aid)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"code": common.StatusCommonOK,
@@ -423,3 +433,42 @@ func UpdateAksk(ctx *gin.Context) {
 		"expire_time": expireStamp,
 	})
 }
+
+func appCacheKey(aid int) string {
+	return "app:" + strconv.Itoa(aid)
+}
+
+// loadApp returns the app from redis, falling back to mysql and caching the
+// result for a minute. An app that does not exist yields a zero App with only
+// the aid set, so that reporting keeps working with the default settings.
+func loadApp(aid int) (app model.App, err error) {
+	rctx := context.Background()
+	key := appCacheKey(aid)
+
+	err = db.Redis().Get(rctx, key).Scan(&app)
+	if err == nil {
+		return
+	}
+
+	if !errors.Is(err, redis.Nil) {
+		etlog.L().Warn("failed to get app from redis", zap.Int("aid", aid), zap.Error(err))
+	}
+	err = nil
+
+	res := db.Mysql().Where("aid = ?", aid).First(&app)
+	if res.Error != nil {
+		if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			err = res.Error
+			return
+		}
+
+		app = model.App{Aid: aid}
+	}
+
+	db.Redis().Set(rctx, key, app, time.Minute)
+	return
+}
+
+func invalidateApp(aid int) {
+	db.Redis().Del(context.Background(), appCacheKey(aid))
+}
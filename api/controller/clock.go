@@ -0,0 +1,110 @@
+//
+// File: clock.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"oset/model"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultSkewTolerance = 2 * time.Second
+	defaultMaxPast       = 7 * 24 * time.Hour
+	defaultMaxFuture     = 5 * time.Minute
+)
+
+const (
+	tagImplausibleTime = "implausible_time"
+	tagClampedTime     = "clamped_time"
+)
+
+func clockPolicy(app model.App) string {
+	switch app.ClockPolicy {
+	case model.CLOCK_POLICY_FLAG, model.CLOCK_POLICY_CLAMP:
+		return app.ClockPolicy
+	}
+
+	if viper.GetString("event.clock.policy") == model.CLOCK_POLICY_CLAMP {
+		return model.CLOCK_POLICY_CLAMP
+	}
+
+	return model.CLOCK_POLICY_FLAG
+}
+
+// estimateSkew compares the time a request was signed on the device with the
+// time it was received. Differences within the tolerance are mostly network
+// latency and are ignored. The signing time has a resolution of a second, and
+// AkskMiddleware rejects requests signed more than 5 minutes before or 1
+// minute after they arrive, so only skews within that window are corrected;
+// devices whose clocks are further off are not accepted at all.
+func estimateSkew(signedAt time.Time, receivedAt time.Time) time.Duration {
+	if signedAt.IsZero() {
+		return 0
+	}
+
+	tolerance := viper.GetDuration("event.clock.skew_tolerance")
+	if tolerance <= 0 {
+		tolerance = defaultSkewTolerance
+	}
+
+	skew := receivedAt.Sub(signedAt)
+	if skew > -tolerance && skew < tolerance {
+		return 0
+	}
+
+	return skew
+}
+
+// applyClock keeps the time reported by the device, corrects it by the clock
+// skew of the request and flags or clamps the result when it is implausible.
+// Events without a client time happened when they were received.
+func applyClock(rc *reportContext, event *model.Event) {
+	event.ServerTime = rc.receivedAt
+	event.ClockSkew = 0
+
+	if event.ClientTime <= 0 {
+		event.Time = rc.receivedAt
+		return
+	}
+
+	event.ClockSkew = rc.skew.Milliseconds()
+	event.Time = time.UnixMilli(event.ClientTime).Add(rc.skew)
+
+	maxPast := viper.GetDuration("event.clock.max_past")
+	if maxPast <= 0 {
+		maxPast = defaultMaxPast
+	}
+
+	maxFuture := viper.GetDuration("event.clock.max_future")
+	if maxFuture <= 0 {
+		maxFuture = defaultMaxFuture
+	}
+
+	earliest := rc.receivedAt.Add(-maxPast)
+	latest := rc.receivedAt.Add(maxFuture)
+	if !event.Time.Before(earliest) && !event.Time.After(latest) {
+		return
+	}
+
+	if rc.clockPolicy == model.CLOCK_POLICY_CLAMP {
+		if event.Time.Before(earliest) {
+			event.Time = earliest
+		} else {
+			event.Time = latest
+		}
+
+		event.Tags = append(event.Tags, tagClampedTime)
+		return
+	}
+
+	event.Tags = append(event.Tags, tagImplausibleTime)
+}
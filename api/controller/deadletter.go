@@ -0,0 +1,387 @@
+//
+// File: deadletter.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common"
+	"oset/component/format"
+	"oset/component/redaction"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeadLetterPageSize = 20
+	maxDeadLetterPageSize     = 200
+)
+
+var (
+	ErrDeadLetterNoAid = errors.New("dead letter has no valid aid, fix it before replaying")
+)
+
+func rejectStage(err error) string {
+	if errors.Is(err, ErrSchemaViolation) {
+		return model.DEADLETTER_STAGE_SCHEMA
+	}
+
+	return model.DEADLETTER_STAGE_DATA
+}
+
+// saveDeadLetter keeps a rejected report together with the request it came from.
+// The body is kept as received, unredacted, so that a replay goes through
+// redaction like any report; dead letters are only readable by admins.
+func saveDeadLetter(ctx *gin.Context, aid int, stage string, body []byte, reason error) {
+	ua := ctx.Request.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+
+	letter := model.DeadLetter{
+		Aid:         aid,
+		Ak:          ctx.GetString("ak"),
+		Stage:       stage,
+		Reason:      reason.Error(),
+		Body:        string(body),
+		ContentType: ctx.ContentType(),
+		ClientIP:    ctx.ClientIP(),
+		UserAgent:   ua,
+		Status:      model.DEADLETTER_STATUS_PENDING,
+	}
+
+	if signedAt, ok := ctx.Get("signed_at"); ok {
+		letter.SignedAt = signedAt.(time.Time).Unix()
+	}
+
+	if format.Parse(letter.ContentType).Binary() {
+		letter.Body = base64.StdEncoding.EncodeToString(body)
+	}
+
+	res := db.Mysql().Create(&letter)
+	if res.Error != nil {
+		etlog.L().Error("failed to save dead letter", zap.Int("aid", aid), zap.String("stage", stage), zap.String("body", redaction.Text(aid, letter.Body)), zap.Error(res.Error))
+	}
+}
+
+// replayDeadLetter runs a dead letter through the normal ingestion path. The
+// body may hold a single event or a whole batch, which is replayed all or nothing.
+// rejected tells an error of the report itself from a failure to deliver it.
+func replayDeadLetter(letter *model.DeadLetter) (rejected bool, err error) {
+	if letter.Aid <= 0 {
+		return false, ErrDeadLetterNoAid
+	}
+
+	body := []byte(letter.Body)
+	if f := format.Parse(letter.ContentType); f.Binary() {
+		raw, err := base64.StdEncoding.DecodeString(letter.Body)
+		if err != nil {
+			return true, err
+		}
+
+		if body, err = format.TranscodeAny(f, raw); err != nil {
+			return true, err
+		}
+	}
+
+	var items []batchItem
+	body = bytes.TrimSpace(body)
+	if single := decodeBatchItem(body); len(body) > 0 && body[0] == '{' && single.err == nil {
+		items = append(items, single)
+	} else if items, err = decodeEventBatch(body); err != nil {
+		return true, err
+	}
+
+	if len(items) == 0 {
+		return true, ErrBatchEmpty
+	}
+
+	rc, err := newReportContext(letter.Aid)
+	if err != nil {
+		return false, err
+	}
+
+	// the events are placed in time as of the original report, not the replay
+	if letter.CreatedAt > 0 {
+		rc.receivedAt = time.Unix(int64(letter.CreatedAt), 0)
+	}
+	if letter.SignedAt > 0 {
+		rc.skew = estimateSkew(time.Unix(letter.SignedAt, 0), rc.receivedAt)
+	}
+	rc.resolveEnrichment(letter.ClientIP, letter.UserAgent)
+
+	events := make([]model.Event, 0, len(items))
+	for _, item := range items {
+		if item.err != nil {
+			return true, item.err
+		}
+
+		_, err := prepareEvent(rc, item.event)
+		if errors.Is(err, ErrSampledOut) {
+			continue
+		} else if err != nil {
+			return true, err
+		}
+
+		events = append(events, *item.event)
+	}
+
+	if len(events) == 0 {
+		// everything was sampled out
+		return false, nil
+	}
+
+	_, err = publishEvents(events)
+	return false, err
+}
+
+func GetDeadLetterList(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	size, err := strconv.Atoi(ctx.DefaultQuery("size", strconv.Itoa(defaultDeadLetterPageSize)))
+	if err != nil || size < 1 {
+		size = defaultDeadLetterPageSize
+	} else if size > maxDeadLetterPageSize {
+		size = maxDeadLetterPageSize
+	}
+
+	query := db.Mysql().Model(&model.DeadLetter{})
+	if said, ok := ctx.GetQuery("aid"); ok {
+		aid, err := strconv.Atoi(said)
+		if err != nil {
+			abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+			return
+		}
+		query = query.Where("aid = ?", aid)
+	}
+	if status, ok := ctx.GetQuery("status"); ok {
+		query = query.Where("status = ?", status)
+	}
+	if stage, ok := ctx.GetQuery("stage"); ok {
+		query = query.Where("stage = ?", stage)
+	}
+
+	var total int64
+	res := query.Count(&total)
+	if res.Error != nil {
+		etlog.L().Error("failed to count dead letters", zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	var letterList []model.DeadLetter
+	res = query.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&letterList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get dead letter list", zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(letterList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":             "success",
+		"total":           total,
+		"deadletter_list": string(jsonBytes),
+	})
+}
+
+// deadLetterFix is an update of a dead letter, fields left out are kept.
+type deadLetterFix struct {
+	ID          int     `json:"id"`
+	Aid         *int    `json:"aid"`
+	Body        *string `json:"body"`
+	ContentType *string `json:"content_type"`
+}
+
+func UpdateDeadLetter(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var fix deadLetterFix
+	err := ctx.BindJSON(&fix)
+	if err != nil {
+		etlog.L().Error("unable to update dead letter, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update dead letter failed")
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if fix.Aid != nil {
+		updates["aid"] = *fix.Aid
+	}
+	if fix.Body != nil {
+		updates["body"] = *fix.Body
+	}
+	if fix.ContentType != nil {
+		updates["content_type"] = *fix.ContentType
+	}
+
+	if len(updates) == 0 {
+		abortCtx(ctx, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	res := db.Mysql().Model(&model.DeadLetter{}).Where("id = ? AND status = ?", fix.ID, model.DEADLETTER_STATUS_PENDING).Updates(updates)
+	if res.Error != nil {
+		etlog.L().Error("update dead letter failed", zap.Int("id", fix.ID), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	if res.RowsAffected == 0 {
+		abortCtx(ctx, http.StatusBadRequest, "the dead letter does not exist or is not pending")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+// DeadLetterReplayResult is the outcome of replaying one dead letter.
+type DeadLetterReplayResult struct {
+	ID       int    `json:"id"`
+	Replayed bool   `json:"replayed"`
+	Error    string `json:"error,omitempty"`
+}
+
+func ReplayDeadLetter(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	err := ctx.BindJSON(&req)
+	if err != nil || len(req.IDs) == 0 {
+		abortCtx(ctx, http.StatusBadRequest, "no dead letter to replay")
+		return
+	}
+
+	results := make([]DeadLetterReplayResult, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		result := DeadLetterReplayResult{ID: id}
+
+		var letter model.DeadLetter
+		res := db.Mysql().Where("id = ?", id).First(&letter)
+		if res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				result.Error = "the dead letter does not exist"
+			} else {
+				etlog.L().Error("failed to load dead letter", zap.Int("id", id), zap.Error(res.Error))
+				result.Error = "unknown error"
+			}
+
+			results = append(results, result)
+			continue
+		}
+
+		if letter.Status != model.DEADLETTER_STATUS_PENDING {
+			result.Error = "the dead letter is " + letter.Status
+			results = append(results, result)
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"replays": gorm.Expr("replays + 1"),
+		}
+
+		rejected, err := replayDeadLetter(&letter)
+		if err != nil {
+			result.Error = err.Error()
+			updates["reason"] = err.Error()
+			if rejected {
+				updates["stage"] = rejectStage(err)
+			}
+		} else {
+			result.Replayed = true
+			updates["status"] = model.DEADLETTER_STATUS_REPLAYED
+		}
+
+		res = db.Mysql().Model(&model.DeadLetter{}).Where("id = ?", id).Updates(updates)
+		if res.Error != nil {
+			etlog.L().Error("failed to update dead letter", zap.Int("id", id), zap.Error(res.Error))
+		}
+
+		etlog.L().Info("replayed dead letter", zap.Int("id", id), zap.Bool("replayed", result.Replayed), zap.String("error", result.Error), zap.Int("operator_uid", requestUser.Uid))
+		results = append(results, result)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"results": results,
+	})
+}
+
+func DiscardDeadLetter(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	res := db.Mysql().Model(&model.DeadLetter{}).Where("id = ? AND status = ?", id, model.DEADLETTER_STATUS_PENDING).Update("status", model.DEADLETTER_STATUS_DISCARDED)
+	if res.Error != nil {
+		etlog.L().Error("discard dead letter failed", zap.Int("id", id), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
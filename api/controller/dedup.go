@@ -0,0 +1,77 @@
+//
+// File: dedup.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDedupWindow = 24 * time.Hour
+)
+
+func dedupKey(aid int, eventID string) string {
+	return "event:dedup:" + strconv.Itoa(aid) + ":" + eventID
+}
+
+// claimEvents marks the event ids of events as seen for the dedup window and
+// reports which events had already been seen. Events without an id are never
+// duplicates. If redis is unavailable every event is let through.
+func claimEvents(events []model.Event) (duplicates []bool, claimed []string) {
+	duplicates = make([]bool, len(events))
+
+	window := viper.GetDuration("event.dedup_window")
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+
+	rctx := context.Background()
+	for i := range events {
+		if events[i].EventID == "" {
+			continue
+		}
+
+		key := dedupKey(events[i].Aid, events[i].EventID)
+		fresh, err := db.Redis().SetNX(rctx, key, 1, window).Result()
+		if err != nil {
+			etlog.L().Warn("failed to check duplicate event", zap.Int("aid", events[i].Aid), zap.String("event_id", events[i].EventID), zap.Error(err))
+			continue
+		}
+
+		if !fresh {
+			duplicates[i] = true
+			continue
+		}
+		claimed = append(claimed, key)
+	}
+
+	return
+}
+
+// releaseEvents forgets claimed event ids, so that a retry of events that
+// could not be written is not taken for a duplicate.
+func releaseEvents(claimed []string) {
+	if len(claimed) == 0 {
+		return
+	}
+
+	err := db.Redis().Del(context.Background(), claimed...).Err()
+	if err != nil {
+		etlog.L().Warn("failed to release event ids", zap.Strings("keys", claimed), zap.Error(err))
+	}
+}
@@ -0,0 +1,47 @@
+//
+// File: enrich.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"errors"
+	"oset/component/geoip"
+	"oset/component/useragent"
+	"oset/model"
+
+	"github.com/Dizzrt/etlog"
+	"go.uber.org/zap"
+)
+
+// resolveEnrichment looks up the client of a report once, for all its events,
+// with the enrichers enabled on the app.
+func (rc *reportContext) resolveEnrichment(clientIP string, ua string) {
+	if rc.app.EnrichGeo && clientIP != "" {
+		geo, err := geoip.Lookup(clientIP)
+		if err != nil {
+			if !errors.Is(err, geoip.ErrNotLoaded) {
+				etlog.L().Warn("failed to resolve client ip", zap.String("ip", clientIP), zap.Error(err))
+			}
+		} else if geo != (model.GeoInfo{}) {
+			rc.geo = &geo
+		}
+	}
+
+	if rc.app.EnrichUA && ua != "" {
+		info := useragent.Parse(ua)
+		rc.ua = &info
+	}
+}
+
+// enrichEvent adds what the proxy knows about the client to an event. Values
+// sent by the client in these fields are never trusted.
+func enrichEvent(rc *reportContext, event *model.Event) {
+	event.Geo = rc.geo
+	event.UA = rc.ua
+}
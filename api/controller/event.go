@@ -10,46 +10,350 @@
 package controller
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"oset/common/stream"
+	"oset/component/format"
+	"oset/component/geoip"
+	"oset/component/redaction"
+	"oset/component/schema"
+	"oset/component/sink"
 	"oset/model"
 	"strconv"
 	"time"
 
 	"github.com/Dizzrt/etlog"
-	"github.com/Dizzrt/etstream/kafka"
 	"github.com/Dizzrt/go-sse"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+const (
+	defaultMaxBatchSize = 1000
+)
+
 var (
-	kafkaWrite *kafka.KafkaWriter
-	sseServer  *sse.Server
+	ErrBatchEmpty      = errors.New("batch is empty")
+	ErrBatchTooLarge   = errors.New("batch exceeds the maximum size")
+	ErrInvalidData     = errors.New("parse data failed")
+	ErrSchemaViolation = schema.ErrViolation
+)
+
+var (
+	sseServer *sse.Server
 )
 
 func InitEvent() {
 	sseServer = sse.NewServer(nil)
+	geoip.Init()
+	sink.Init()
+	InitStats()
+
+	if viper.GetBool("session.enabled") {
+		sessionStop = make(chan struct{})
+		go runSessionSweeper(sessionStop)
+	}
 
-	kconfig := kafka.KafkaConfig{
-		SaramaConfig: kafka.DefaultProducerConfig(),
-		Host:         viper.GetString("kafka.host"),
-		Topic:        "events",
+	if viper.GetBool("active.enabled") {
+		activeStop = make(chan struct{})
+		go runActivePusher(activeStop)
 	}
 
-	kw, err := kafka.NewKafkaWriter(kconfig, nil, nil)
+	startAlerts()
+}
+
+// CloseEvent closes the event sinks. Anything still spooled is drained on the
+// next start.
+func CloseEvent() {
+	if sessionStop != nil {
+		close(sessionStop)
+	}
+	if activeStop != nil {
+		close(activeStop)
+	}
+	if alertStop != nil {
+		close(alertStop)
+	}
+	sink.Close()
+}
+
+// reportContext carries what is shared by every event of one report request.
+type reportContext struct {
+	aid         int
+	app         model.App
+	schemaMode  string
+	clockPolicy string
+	receivedAt  time.Time
+	skew        time.Duration
+	geo         *model.GeoInfo
+	ua          *model.UserAgentInfo
+
+	// seen are the events that passed the identity checks, sampledOut those
+	// of them dropped by sampling; see recordSeen
+	seen       []model.Event
+	sampledOut []model.Event
+}
+
+func newReportContext(aid int) (*reportContext, error) {
+	app, err := loadApp(aid)
 	if err != nil {
-		etlog.L().Panic("failed to create kafka writer", zap.Error(err))
+		return nil, err
+	}
+
+	rc := &reportContext{
+		aid:         aid,
+		app:         app,
+		schemaMode:  app.SchemaMode,
+		clockPolicy: clockPolicy(app),
+		receivedAt:  time.Now(),
+	}
+
+	switch rc.schemaMode {
+	case model.SCHEMA_MODE_REJECT, model.SCHEMA_MODE_WARN, model.SCHEMA_MODE_TAG:
+	default:
+		rc.schemaMode = viper.GetString("schema.mode")
+		if rc.schemaMode != model.SCHEMA_MODE_REJECT && rc.schemaMode != model.SCHEMA_MODE_TAG {
+			rc.schemaMode = model.SCHEMA_MODE_WARN
+		}
 	}
 
-	kafkaWrite = kw
+	return rc, nil
+}
+
+// bindRequest takes the request level facts checked by the aksk middleware
+// and resolves the client of the request.
+func (rc *reportContext) bindRequest(ctx *gin.Context) {
+	if signedAt, ok := ctx.Get("signed_at"); ok {
+		rc.skew = estimateSkew(signedAt.(time.Time), rc.receivedAt)
+	}
+
+	rc.resolveEnrichment(ctx.ClientIP(), ctx.Request.UserAgent())
+}
+
+// transcodeBody replaces a report sent in a binary format by its json form, so
+// that binding, dead letters and replays only ever deal with json.
+func transcodeBody(ctx *gin.Context, body []byte, batch bool) ([]byte, error) {
+	f := format.Parse(ctx.ContentType())
+	if !f.Binary() {
+		return body, nil
+	}
+
+	var (
+		jbody []byte
+		err   error
+	)
+	if batch {
+		jbody, err = format.TranscodeBatch(f, body)
+	} else {
+		jbody, err = format.Transcode(f, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(jbody))
+	ctx.Request.ContentLength = int64(len(jbody))
+	ctx.Request.Header.Set("Content-Type", binding.MIMEJSON)
+	return jbody, nil
+}
+
+// checkSchema validates an event against the schema registry of its app and
+// applies the schema mode of the app to any violation.
+func checkSchema(rc *reportContext, event *model.Event, data map[string]interface{}) error {
+	res, err := schema.Check(rc.aid, event.Event, data)
+	if err != nil {
+		// the registry being unavailable must not stop ingestion
+		etlog.L().Error("failed to load event schema", zap.Int("aid", rc.aid), zap.String("event", event.Event), zap.Error(err))
+		return nil
+	}
+
+	if !res.Enforced {
+		return nil
+	}
+
+	event.SchemaVersion = res.Version
+	if res.Deprecated {
+		etlog.L().Warn("received deprecated event", zap.Int("aid", rc.aid), zap.String("event", event.Event))
+	}
+
+	if len(res.Violations) > 0 {
+		etlog.L().Warn("event does not match its schema", zap.Int("aid", rc.aid), zap.String("event", event.Event), zap.String("mode", rc.schemaMode), zap.Strings("violations", res.Violations))
+	}
+
+	tags, err := res.Apply(rc.schemaMode)
+	event.Tags = append(event.Tags, tags...)
+	return err
+}
+
+// prepareEvent checks a reported event and fills in the fields owned by the proxy,
+// returning the event as json. Events dropped by sampling return ErrSampledOut.
+func prepareEvent(rc *reportContext, event *model.Event) (jevent []byte, err error) {
+	event.Aid = rc.aid
+	event.SchemaVersion = 0
+	event.Tags = nil
+	event.SampleRate = 0
+	event.SessionID = ""
+
+	err = checkIdentity(event.Did, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	applyClock(rc, event)
+	enrichEvent(rc, event)
+	rc.seen = append(rc.seen, *event)
+
+	// invalid events are rejected whether or not sampling would keep them
+	data := make(map[string]interface{})
+	err = json.Unmarshal([]byte(event.Data), &data)
+	if err != nil {
+		etlog.L().Warn("unable to receive event, because parse reported data failed", zap.String("event", event.Event), zap.String("raw_data", redaction.Text(rc.aid, event.Data)), zap.Error(err))
+		return nil, fmt.Errorf("%w: %s", ErrInvalidData, err.Error())
+	}
+
+	err = checkSchema(rc, event, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sampleEvent(rc, event) {
+		// dropped events still count as unique devices, redacted first as
+		// their data may be grouped by
+		if statsConfig != nil && redactEvent(rc, event) == nil {
+			rc.sampledOut = append(rc.sampledOut, *event)
+		}
+		return nil, ErrSampledOut
+	}
+
+	err = redactEvent(rc, event)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Did == "" {
+		etlog.L().Warn("report without did", zap.Any("raw_event", event))
+	}
+
+	jevent, err = json.Marshal(event)
+	if err != nil {
+		etlog.L().Warn("unable to receive event, beacause convert event to json failed", zap.Any("raw_event", event), zap.Error(err))
+		return nil, fmt.Errorf("convert data failed: %w", err)
+	}
+
+	return
+}
+
+// sendRealtime pushes events to the realtime channels of their devices.
+func sendRealtime(events []model.Event) {
+	for i := range events {
+		jevent, err := json.Marshal(events[i])
+		if err != nil {
+			continue
+		}
+
+		sseServer.SendMessage(fmt.Sprintf("/event/tool/realtime/%d/%s", events[i].Aid, events[i].Did), sse.SimpleMessage(string(jevent)))
+	}
+}
+
+// publishEvents puts prepared events into sessions, writes them to the event
+// sinks in one call, pushes them to their realtime channels and counts them.
+// Events whose id was already reported within the dedup window are skipped
+// and flagged in duplicates, the rest are counted against the quota of their
+// app, which all of them belong to.
+func publishEvents(events []model.Event) (duplicates []bool, err error) {
+	duplicates, claimed := claimEvents(events)
+
+	fresh := make([]model.Event, 0, len(events))
+	for i := range events {
+		if duplicates[i] {
+			etlog.L().Info("dropped duplicate event", zap.Int("aid", events[i].Aid), zap.String("event_id", events[i].EventID))
+			continue
+		}
+
+		fresh = append(fresh, events[i])
+	}
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	// only the reported events count against the quota, not session events
+	aid, cost, now := fresh[0].Aid, len(fresh), time.Now()
+	err = countQuota(aid, now, cost)
+	if err != nil {
+		releaseEvents(claimed)
+		return
+	}
+
+	fresh, assigned := sessionize(fresh)
+
+	err = sink.Write(fresh)
+	var fe *sink.FanOutError
+	if err != nil && (!errors.As(err, &fe) || len(fe.Accepted) == 0) {
+		releaseSessions(assigned)
+		releaseEvents(claimed)
+		refundQuota(aid, now, cost)
+		return
+	}
+
+	// events taken by some of the sinks are out, their claims stay so that a
+	// retry does not write them to those sinks again
+	sendRealtime(fresh)
+	recordStats(fresh)
+	return
 }
 
 func ReportEvent(ctx *gin.Context) {
+	body, err := stream.GetRawBody(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "read body error",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		etlog.L().Warn("unable to receive event, because read body failed", zap.Error(err))
+		return
+	}
+
+	said := ctx.Param("aid")
+	aid, err := strconv.Atoi(said)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "invalid aid",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		saveDeadLetter(ctx, 0, model.DEADLETTER_STAGE_AID, body, fmt.Errorf("invalid aid %q: %w", said, err))
+		etlog.L().Warn("unable to receive event, because invalid aid", zap.String("target_aid", said), zap.Error(err))
+		return
+	}
+
+	jbody, err := transcodeBody(ctx, body, false)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "decode body error",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		saveDeadLetter(ctx, aid, model.DEADLETTER_STAGE_BIND, body, err)
+		etlog.L().Warn("unable to receive event, because decode body failed", zap.String("content_type", ctx.ContentType()), zap.Error(err))
+		return
+	}
+	body = jbody
+	setLogBody(ctx, aid, body)
+
 	event := model.Event{}
-	err := ctx.BindJSON(&event)
+	err = ctx.BindJSON(&event)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "bind json error",
@@ -57,10 +361,152 @@ func ReportEvent(ctx *gin.Context) {
 		})
 		ctx.Abort()
 
-		etlog.L().Warn("unable to receive event, because bind json failed", zap.String("event", event.Event), zap.String("raw_data", event.Data), zap.Error(err))
+		saveDeadLetter(ctx, aid, model.DEADLETTER_STAGE_BIND, body, err)
+		etlog.L().Warn("unable to receive event, because bind json failed", zap.String("event", event.Event), zap.String("raw_data", redaction.Text(aid, event.Data)), zap.Error(err))
+		return
+	}
+
+	rc, err := newReportContext(aid)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "load app failed",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		etlog.L().Error("unable to receive event, because load app failed", zap.Int("aid", aid), zap.Error(err))
+		return
+	}
+	rc.bindRequest(ctx)
+
+	_, err = limitReport(ctx, rc, []string{string(event.Did)})
+	if err != nil {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to receive event, because it is over a limit", zap.Int("aid", aid), zap.String("ak", ctx.GetString("ak")), zap.Error(err))
+		return
+	}
+
+	jevent, err := prepareEvent(rc, &event)
+	recordSeen(rc)
+	if errors.Is(err, ErrSampledOut) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"msg":         "success",
+			"duplicate":   false,
+			"sampled_out": true,
+		})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "invalid event",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		saveDeadLetter(ctx, aid, rejectStage(err), body, err)
+		return
+	}
+
+	duplicates, err := publishEvents([]model.Event{event})
+	var lerr *LimitError
+	if errors.As(err, &lerr) {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to receive event, because it is over a quota", zap.Int("aid", aid), zap.Error(err))
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "write event failed",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		etlog.L().Error("failed to write event to sinks", zap.Int("aid", aid), zap.Error(err))
+		return
+	}
+	ctx.Set("log_body", string(jevent))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":       "success",
+		"duplicate": duplicates[0],
+	})
+}
+
+// BatchResult is the per item outcome of a batch report, in request order.
+type BatchResult struct {
+	Index      int    `json:"index"`
+	Accepted   bool   `json:"accepted"`
+	Duplicate  bool   `json:"duplicate,omitempty"`
+	SampledOut bool   `json:"sampled_out,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// batchItem is one entry of a batch body. Items that fail to decode keep their
+// error so that the rest of the batch can still be accepted.
+type batchItem struct {
+	raw   []byte
+	event *model.Event
+	err   error
+}
+
+func decodeBatchItem(raw []byte) batchItem {
+	item := batchItem{
+		raw:   raw,
+		event: &model.Event{},
+	}
+
+	item.err = json.Unmarshal(raw, item.event)
+	return item
+}
+
+// decodeEventBatch reads a batch body, either as a json array or as ndjson
+// (one event per line).
+func decodeEventBatch(body []byte) (items []batchItem, err error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, ErrBatchEmpty
+	}
+
+	if body[0] == '[' {
+		var raws []json.RawMessage
+		if err = json.Unmarshal(body, &raws); err != nil {
+			return nil, err
+		}
+
+		for _, raw := range raws {
+			items = append(items, decodeBatchItem(raw))
+		}
+		return
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, rerr := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			items = append(items, decodeBatchItem(line))
+		}
+
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil {
+			return nil, rerr
+		}
+	}
+
+	return
+}
+
+func ReportEventBatch(ctx *gin.Context) {
+	body, err := stream.GetRawBody(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "read body error",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		etlog.L().Warn("unable to receive event batch, because read body failed", zap.Error(err))
 		return
 	}
-	event.Time = time.Now()
 
 	said := ctx.Param("aid")
 	aid, err := strconv.Atoi(said)
@@ -71,45 +517,152 @@ func ReportEvent(ctx *gin.Context) {
 		})
 		ctx.Abort()
 
-		etlog.L().Warn("unable to receive event, because invalid aid", zap.String("event", event.Event), zap.String("raw_data", event.Data), zap.String("target_aid", said), zap.Error(err))
+		saveDeadLetter(ctx, 0, model.DEADLETTER_STAGE_AID, body, fmt.Errorf("invalid aid %q: %w", said, err))
+		etlog.L().Warn("unable to receive event batch, because invalid aid", zap.String("target_aid", said), zap.Error(err))
 		return
 	}
-	event.Aid = aid
 
-	data := make(map[string]interface{})
-	err = json.Unmarshal([]byte(event.Data), &data)
+	rc, err := newReportContext(aid)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":   "parse data failed",
+			"msg":   "load app failed",
+			"error": err.Error(),
+		})
+		ctx.Abort()
+
+		etlog.L().Error("unable to receive event batch, because load app failed", zap.Int("aid", aid), zap.Error(err))
+		return
+	}
+	rc.bindRequest(ctx)
+
+	jbody, err := transcodeBody(ctx, body, true)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "decode body error",
 			"error": err.Error(),
 		})
 		ctx.Abort()
 
-		etlog.L().Warn("unable to receive event, because parse reported data failed", zap.String("event", event.Event), zap.String("raw_data", event.Data), zap.Error(err))
+		saveDeadLetter(ctx, aid, model.DEADLETTER_STAGE_BIND, body, err)
+		etlog.L().Warn("unable to receive event batch, because decode body failed", zap.Int("aid", aid), zap.String("content_type", ctx.ContentType()), zap.Error(err))
 		return
 	}
+	body = jbody
+	setLogBody(ctx, aid, body)
 
-	if event.Did == 0 {
-		etlog.L().Warn("report without did (did is 0)", zap.Any("raw_event", event))
+	items, err := decodeEventBatch(body)
+	if err == nil && len(items) == 0 {
+		err = ErrBatchEmpty
+	}
+
+	maxBatchSize := viper.GetInt("event.max_batch_size")
+	if maxBatchSize <= 0 {
+		maxBatchSize = defaultMaxBatchSize
+	}
+	if err == nil && len(items) > maxBatchSize {
+		err = ErrBatchTooLarge
 	}
 
-	jevent, err := json.Marshal(event)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":   "convert data failed",
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "invalid batch",
 			"error": err.Error(),
 		})
 		ctx.Abort()
 
-		etlog.L().Warn("unable to receive event, beacause convert event to json failed", zap.Any("raw_event", event), zap.Error(err))
+		saveDeadLetter(ctx, aid, model.DEADLETTER_STAGE_BIND, body, err)
+		etlog.L().Warn("unable to receive event batch, because decode batch failed", zap.Int("aid", aid), zap.Error(err))
 		return
 	}
 
-	sseServer.SendMessage(fmt.Sprintf("/event/tool/realtime/%d/%d", aid, event.Did), sse.SimpleMessage(string(jevent)))
+	dids := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.err == nil {
+			dids = append(dids, string(item.event.Did))
+		}
+	}
+
+	allowed, err := limitReport(ctx, rc, dids)
+	if err != nil {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to receive event batch, because it is over a limit", zap.Int("aid", aid), zap.String("ak", ctx.GetString("ak")), zap.Int("size", len(items)), zap.Error(err))
+		return
+	}
+
+	// items over the limit of their device are rejected without a dead letter
+	limited := make([]bool, len(items))
+	for i, j := 0, 0; i < len(items); i++ {
+		if items[i].err == nil {
+			limited[i] = !allowed[j]
+			j++
+		}
+	}
+
+	results := make([]BatchResult, len(items))
+	accepted := make([]model.Event, 0, len(items))
+	acceptedIdx := make([]int, 0, len(items))
+	jevents := make([][]byte, 0, len(items))
+	for i, item := range items {
+		results[i].Index = i
+		if item.err != nil {
+			results[i].Error = "bind json failed: " + item.err.Error()
+			saveDeadLetter(ctx, aid, model.DEADLETTER_STAGE_BIND, item.raw, item.err)
+			etlog.L().Warn("unable to receive event, because bind json failed", zap.Int("aid", aid), zap.Int("index", i), zap.Error(item.err))
+			continue
+		}
+
+		if limited[i] {
+			results[i].Error = (&LimitError{Scope: limitScopeDid}).Error()
+			continue
+		}
+
+		jevent, err := prepareEvent(rc, item.event)
+		if errors.Is(err, ErrSampledOut) {
+			results[i].Accepted = true
+			results[i].SampledOut = true
+			continue
+		} else if err != nil {
+			results[i].Error = err.Error()
+			saveDeadLetter(ctx, aid, rejectStage(err), item.raw, err)
+			continue
+		}
+
+		results[i].Accepted = true
+		accepted = append(accepted, *item.event)
+		acceptedIdx = append(acceptedIdx, i)
+		jevents = append(jevents, jevent)
+	}
+	recordSeen(rc)
+
+	if len(accepted) > 0 {
+		duplicates, err := publishEvents(accepted)
+		var lerr *LimitError
+		if errors.As(err, &lerr) {
+			abortLimited(ctx, err)
+			etlog.L().Warn("unable to receive event batch, because it is over a quota", zap.Int("aid", aid), zap.Int("size", len(accepted)), zap.Error(err))
+			return
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"msg":   "write event failed",
+				"error": err.Error(),
+			})
+			ctx.Abort()
+
+			etlog.L().Error("failed to write event batch to sinks", zap.Int("aid", aid), zap.Int("size", len(accepted)), zap.Error(err))
+			return
+		}
+
+		for i, duplicate := range duplicates {
+			results[acceptedIdx[i]].Duplicate = duplicate
+		}
+		ctx.Set("log_body", string(bytes.Join(jevents, []byte("\n"))))
+	}
 
-	kafkaWrite.Write(jevent)
 	ctx.JSON(http.StatusOK, gin.H{
-		"msg": "success",
+		"msg":      "success",
+		"accepted": len(accepted),
+		"rejected": len(items) - len(accepted),
+		"results":  results,
 	})
 }
 
@@ -129,18 +682,17 @@ func RegisterRealtimeEvent(ctx *gin.Context) {
 		return
 	}
 
-	did, err := strconv.Atoi(sdid)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":   "invalid did",
-			"error": err.Error(),
+	err = checkIdentity(model.DeviceID(sdid), "")
+	if err != nil || sdid == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg": "invalid did",
 		})
 		ctx.Abort()
 
-		etlog.L().Warn("unable to register realtime event service, because invalid did", zap.String("target_aid", said), zap.String("target_did", sdid), zap.Error(err))
+		etlog.L().Warn("unable to register realtime event service, because invalid did", zap.String("target_aid", said), zap.String("target_did", sdid))
 		return
 	}
 
-	etlog.L().Info("registerd realtime event", zap.Int("aid", aid), zap.Int("did", did))
+	etlog.L().Info("registerd realtime event", zap.Int("aid", aid), zap.String("did", sdid))
 	sseServer.ServeHTTP(ctx.Writer, ctx.Request)
 }
@@ -0,0 +1,305 @@
+//
+// File: identity.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common/stream"
+	"oset/component/sink"
+	"oset/db"
+	"oset/model"
+	"strconv"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	eventIdentify = "$identify"
+	eventAlias    = "$alias"
+
+	// maxIdentityLength is the size of the did and user_id columns
+	maxIdentityLength = 128
+)
+
+var (
+	ErrInvalidIdentity = errors.New("did, user_id and alias must be at most 128 bytes")
+)
+
+func checkIdentity(did model.DeviceID, userID string) error {
+	if len(did) > maxIdentityLength || len(userID) > maxIdentityLength {
+		return ErrInvalidIdentity
+	}
+
+	return nil
+}
+
+type identifyRequest struct {
+	Did        model.DeviceID `json:"did"`
+	UserID     string         `json:"user_id"`
+	ClientTime int64          `json:"client_time"`
+}
+
+type aliasRequest struct {
+	UserID     string `json:"user_id"`
+	Alias      string `json:"alias"`
+	ClientTime int64  `json:"client_time"`
+}
+
+// identityContext loads the app of an identify, alias or profile call and
+// hands the logger its redacted body, aborting the request when it can not.
+func identityContext(ctx *gin.Context) (*reportContext, bool) {
+	aid, err := strconv.Atoi(ctx.Param("aid"))
+	if err != nil {
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return nil, false
+	}
+
+	rc, err := newReportContext(aid)
+	if err != nil {
+		etlog.L().Error("unable to load app of identity call", zap.Int("aid", aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "load app failed")
+		return nil, false
+	}
+	rc.bindRequest(ctx)
+
+	if body, err := stream.GetRawBody(ctx); err == nil {
+		setLogBody(ctx, aid, body)
+	}
+
+	return rc, true
+}
+
+// linkIdentities links the devices to a user, links that exist are kept as is.
+func linkIdentities(aid int, dids []string, userID string, source string) error {
+	if len(dids) == 0 {
+		return nil
+	}
+
+	rows := make([]model.Identity, 0, len(dids))
+	for _, did := range dids {
+		rows = append(rows, model.Identity{
+			Aid:    aid,
+			Did:    did,
+			UserID: userID,
+			Source: source,
+		})
+	}
+
+	return db.Mysql().Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error
+}
+
+// emitEvent writes an event raised by an identify, alias or profile call, so
+// that consumers of the event stream see the change as well. The caller has
+// counted the event against the quota, which is refunded when it is not written.
+func emitEvent(rc *reportContext, event model.Event, data map[string]interface{}) error {
+	event.Aid = rc.aid
+	applyClock(rc, &event)
+	enrichEvent(rc, &event)
+
+	jdata, _ := json.Marshal(data)
+	event.Data = string(jdata)
+	err := redactEvent(rc, &event)
+	if err == nil {
+		err = sink.Write([]model.Event{event})
+	}
+	if err != nil {
+		etlog.L().Error("failed to write event to sinks", zap.Int("aid", rc.aid), zap.String("event", event.Event), zap.Error(err))
+		refundQuota(rc.aid, rc.receivedAt, 1)
+		return err
+	}
+
+	events := []model.Event{event}
+	sendRealtime(events)
+	recordStats(events)
+	return nil
+}
+
+// Identify links the device of the request to a known user.
+func Identify(ctx *gin.Context) {
+	rc, ok := identityContext(ctx)
+	if !ok {
+		return
+	}
+
+	var req identifyRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		etlog.L().Warn("unable to identify device, because bindjson failed", zap.Int("aid", rc.aid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid identify request")
+		return
+	}
+
+	if req.Did == "" || req.UserID == "" {
+		abortCtx(ctx, http.StatusBadRequest, "did and user_id are required")
+		return
+	}
+
+	if err = checkIdentity(req.Did, req.UserID); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the call writes one event, which is not deduplicated or sampled
+	_, err = limitReport(ctx, rc, []string{string(req.Did)})
+	if err == nil {
+		err = countQuota(rc.aid, rc.receivedAt, 1)
+	}
+	if err != nil {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to identify device, because it is over a limit", zap.Int("aid", rc.aid), zap.Error(err))
+		return
+	}
+
+	err = linkIdentities(rc.aid, []string{string(req.Did)}, req.UserID, model.IDENTITY_SOURCE_IDENTIFY)
+	if err != nil {
+		etlog.L().Error("failed to link device to user", zap.Int("aid", rc.aid), zap.String("did", string(req.Did)), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "identify failed")
+		return
+	}
+
+	err = emitEvent(rc, model.Event{
+		Did:        req.Did,
+		UserID:     req.UserID,
+		Event:      eventIdentify,
+		ClientTime: req.ClientTime,
+	}, map[string]interface{}{})
+	if err != nil {
+		abortCtx(ctx, http.StatusInternalServerError, "identify failed")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg": "success",
+	})
+}
+
+// Alias merges the user alias into user_id by linking every device of alias
+// to user_id as well. The links of alias are kept.
+func Alias(ctx *gin.Context) {
+	rc, ok := identityContext(ctx)
+	if !ok {
+		return
+	}
+
+	var req aliasRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		etlog.L().Warn("unable to alias user, because bindjson failed", zap.Int("aid", rc.aid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid alias request")
+		return
+	}
+
+	if req.UserID == "" || req.Alias == "" || req.UserID == req.Alias {
+		abortCtx(ctx, http.StatusBadRequest, "user_id and a different alias are required")
+		return
+	}
+
+	// the alias is a user id as well
+	if err = checkIdentity("", req.UserID); err == nil {
+		err = checkIdentity("", req.Alias)
+	}
+	if err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the call writes one event without a device
+	_, err = limitReport(ctx, rc, []string{""})
+	if err == nil {
+		err = countQuota(rc.aid, rc.receivedAt, 1)
+	}
+	if err != nil {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to alias user, because it is over a limit", zap.Int("aid", rc.aid), zap.Error(err))
+		return
+	}
+
+	var dids []string
+	res := db.Mysql().Model(&model.Identity{}).Where("aid = ? AND user_id = ?", rc.aid, req.Alias).Distinct().Pluck("did", &dids)
+	if res.Error != nil {
+		etlog.L().Error("failed to get devices of alias", zap.Int("aid", rc.aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "alias failed")
+		return
+	}
+
+	err = linkIdentities(rc.aid, dids, req.UserID, model.IDENTITY_SOURCE_ALIAS)
+	if err != nil {
+		etlog.L().Error("failed to link devices of alias to user", zap.Int("aid", rc.aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "alias failed")
+		return
+	}
+
+	err = emitEvent(rc, model.Event{
+		UserID:     req.UserID,
+		Event:      eventAlias,
+		ClientTime: req.ClientTime,
+	}, map[string]interface{}{
+		"alias":   req.Alias,
+		"devices": len(dids),
+	})
+	if err != nil {
+		abortCtx(ctx, http.StatusInternalServerError, "alias failed")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"devices": len(dids),
+	})
+}
+
+func GetIdentityList(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get identity list failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	did := ctx.Query("did")
+	userID := ctx.Query("user_id")
+	if did == "" && userID == "" {
+		abortCtx(ctx, http.StatusBadRequest, "did or user_id is required")
+		return
+	}
+
+	query := db.Mysql().Where("aid = ?", aid)
+	if did != "" {
+		query = query.Where("did = ?", did)
+	}
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var identityList []model.Identity
+	res := query.Order("id").Find(&identityList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get identity list", zap.Int("aid", aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(identityList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":           "success",
+		"identity_list": string(jsonBytes),
+	})
+}
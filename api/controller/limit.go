@@ -0,0 +1,355 @@
+//
+// File: limit.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"oset/common"
+	"oset/component/ratelimit"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	limitScopeAid     = "app"
+	limitScopeAk      = "access key"
+	limitScopeDid     = "device"
+	limitScopeDaily   = "daily quota"
+	limitScopeMonthly = "monthly quota"
+)
+
+// LimitError is returned for reports over one of the limits of their app.
+type LimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	if e.Scope == limitScopeDaily || e.Scope == limitScopeMonthly {
+		return e.Scope + " exceeded"
+	}
+
+	return e.Scope + " rate limit exceeded"
+}
+
+func limitCacheKey(aid int) string {
+	return "app:limit:" + strconv.Itoa(aid)
+}
+
+// defaultLimit is the limit of apps that have none of their own.
+func defaultLimit(aid int) model.AppLimit {
+	return model.AppLimit{
+		Aid:          aid,
+		AidRate:      viper.GetFloat64("limit.aid_rate"),
+		AidBurst:     viper.GetInt("limit.aid_burst"),
+		AkRate:       viper.GetFloat64("limit.ak_rate"),
+		AkBurst:      viper.GetInt("limit.ak_burst"),
+		DidRate:      viper.GetFloat64("limit.did_rate"),
+		DidBurst:     viper.GetInt("limit.did_burst"),
+		DailyQuota:   viper.GetInt64("limit.daily_quota"),
+		MonthlyQuota: viper.GetInt64("limit.monthly_quota"),
+	}
+}
+
+// loadLimit returns the limit of an app the same way loadApp returns the app.
+func loadLimit(aid int) (limit model.AppLimit, err error) {
+	rctx := context.Background()
+	key := limitCacheKey(aid)
+
+	err = db.Redis().Get(rctx, key).Scan(&limit)
+	if err == nil {
+		return
+	}
+
+	if !errors.Is(err, redis.Nil) {
+		etlog.L().Warn("failed to get app limit from redis", zap.Int("aid", aid), zap.Error(err))
+	}
+	err = nil
+
+	res := db.Mysql().Where("aid = ?", aid).First(&limit)
+	if res.Error != nil {
+		if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			err = res.Error
+			return
+		}
+
+		limit = defaultLimit(aid)
+	}
+
+	db.Redis().Set(rctx, key, limit, time.Minute)
+	return
+}
+
+func invalidateLimit(aid int) {
+	db.Redis().Del(context.Background(), limitCacheKey(aid))
+}
+
+func quotaKeys(aid int, now time.Time) (dayKey, monthKey string) {
+	dayKey = "quota:day:" + strconv.Itoa(aid) + ":" + now.Format("20060102")
+	monthKey = "quota:month:" + strconv.Itoa(aid) + ":" + now.Format("200601")
+	return
+}
+
+// quotaPeriods returns how long until the current day and month end.
+func quotaPeriods(now time.Time) (day, month time.Duration) {
+	y, m, d := now.Date()
+	day = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+	month = time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location()).Sub(now)
+	return
+}
+
+// limitReport takes the events of a report from the token buckets of the app,
+// the access key and every device, events without a did only from the first
+// two. Tokens of events that are then held back are given back. A LimitError
+// means the whole report is over a limit; otherwise allowed tells which events
+// passed the limit of their device. Limits are not enforced while redis is
+// unavailable. The quotas are counted by countQuota once the events that will
+// be written are known.
+func limitReport(ctx *gin.Context, rc *reportContext, dids []string) (allowed []bool, err error) {
+	allowed = make([]bool, len(dids))
+	for i := range allowed {
+		allowed[i] = true
+	}
+
+	limit, err := loadLimit(rc.aid)
+	if err != nil {
+		etlog.L().Error("failed to load app limit", zap.Int("aid", rc.aid), zap.Error(err))
+		return allowed, nil
+	}
+
+	rctx := context.Background()
+	take := func(scope, key string, bucket ratelimit.Bucket, cost int) error {
+		ok, wait, err := ratelimit.Take(rctx, db.Redis(), key, bucket, cost)
+		if err != nil {
+			etlog.L().Warn("failed to take from rate limit bucket", zap.String("key", key), zap.Error(err))
+			return nil
+		}
+
+		if !ok {
+			return &LimitError{Scope: scope, RetryAfter: wait}
+		}
+		return nil
+	}
+
+	// tokens taken from the buckets of the app and access key are given back
+	// for the events a later bucket does not let through
+	type taken struct {
+		key    string
+		bucket ratelimit.Bucket
+	}
+	var takens []taken
+	giveBack := func(cost int) {
+		for _, t := range takens {
+			if err := ratelimit.Give(rctx, db.Redis(), t.key, t.bucket, cost); err != nil {
+				etlog.L().Warn("failed to give back to rate limit bucket", zap.String("key", t.key), zap.Error(err))
+			}
+		}
+	}
+
+	aidKey, aidBucket := fmt.Sprintf("limit:aid:%d", rc.aid), ratelimit.Bucket{Rate: limit.AidRate, Burst: limit.AidBurst}
+	err = take(limitScopeAid, aidKey, aidBucket, len(dids))
+	if err != nil {
+		return
+	}
+	takens = append(takens, taken{aidKey, aidBucket})
+
+	if ak := ctx.GetString("ak"); ak != "" {
+		akKey, akBucket := "limit:ak:"+ak, ratelimit.Bucket{Rate: limit.AkRate, Burst: limit.AkBurst}
+		err = take(limitScopeAk, akKey, akBucket, len(dids))
+		if err != nil {
+			giveBack(len(dids))
+			return
+		}
+		takens = append(takens, taken{akKey, akBucket})
+	}
+
+	var deviceErr error
+	bucket := ratelimit.Bucket{Rate: limit.DidRate, Burst: limit.DidBurst}
+	if bucket.Enabled() {
+		// events without a did do not belong to any one device
+		costs := make(map[string]int)
+		for _, did := range dids {
+			if did != "" {
+				costs[did]++
+			}
+		}
+
+		denied := make(map[string]bool)
+		deniedCost := 0
+		for did, cost := range costs {
+			if derr := take(limitScopeDid, fmt.Sprintf("limit:did:%d:%s", rc.aid, did), bucket, cost); derr != nil {
+				denied[did] = true
+				deniedCost += cost
+				deviceErr = derr
+			}
+		}
+
+		for i, did := range dids {
+			allowed[i] = !denied[did]
+		}
+		giveBack(deniedCost)
+	}
+
+	for _, ok := range allowed {
+		if ok {
+			return
+		}
+	}
+
+	// every event is over the limit of its device
+	return allowed, deviceErr
+}
+
+// countQuota counts cost events of an app that are about to be written against
+// its quotas, returning a LimitError when that would exceed one. Reports count
+// only their fresh events, so retried, duplicate and sampled out events do not
+// use up the quota. Quotas are not enforced while redis is unavailable.
+func countQuota(aid int, now time.Time, cost int) error {
+	limit, err := loadLimit(aid)
+	if err != nil {
+		etlog.L().Error("failed to load app limit", zap.Int("aid", aid), zap.Error(err))
+		return nil
+	}
+
+	dayKey, monthKey := quotaKeys(aid, now)
+	dayLeft, monthLeft := quotaPeriods(now)
+	res, err := ratelimit.Count(context.Background(), db.Redis(), dayKey, monthKey, limit.DailyQuota, limit.MonthlyQuota, dayLeft, monthLeft, cost)
+	if err != nil {
+		etlog.L().Warn("failed to count events against quota", zap.Int("aid", aid), zap.Error(err))
+		return nil
+	}
+
+	switch res {
+	case ratelimit.QuotaDaily:
+		return &LimitError{Scope: limitScopeDaily, RetryAfter: dayLeft}
+	case ratelimit.QuotaMonthly:
+		return &LimitError{Scope: limitScopeMonthly, RetryAfter: monthLeft}
+	}
+
+	return nil
+}
+
+// refundQuota gives back the quota of events counted at now that were not
+// written after all.
+func refundQuota(aid int, now time.Time, cost int) {
+	dayKey, monthKey := quotaKeys(aid, now)
+	if err := ratelimit.Refund(context.Background(), db.Redis(), dayKey, monthKey, cost); err != nil {
+		etlog.L().Warn("failed to refund quota", zap.Int("aid", aid), zap.Int("cost", cost), zap.Error(err))
+	}
+}
+
+// abortLimited answers a report over a limit with 429 and a Retry-After in
+// whole seconds.
+func abortLimited(ctx *gin.Context, err error) {
+	retryAfter := 1
+	var lerr *LimitError
+	if errors.As(err, &lerr) {
+		if secs := int((lerr.RetryAfter + time.Second - 1) / time.Second); secs > retryAfter {
+			retryAfter = secs
+		}
+	}
+
+	ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"msg":         "too many requests",
+		"error":       err.Error(),
+		"retry_after": retryAfter,
+	})
+	ctx.Abort()
+}
+
+func GetAppLimit(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	limit, err := loadLimit(aid)
+	if err != nil {
+		etlog.L().Error("failed to get app limit", zap.Int("aid", aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(limit)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	rctx := context.Background()
+	dayKey, monthKey := quotaKeys(aid, time.Now())
+	dailyUsed, _ := db.Redis().Get(rctx, dayKey).Int64()
+	monthlyUsed, _ := db.Redis().Get(rctx, monthKey).Int64()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":          "success",
+		"limit":        string(jsonBytes),
+		"daily_used":   dailyUsed,
+		"monthly_used": monthlyUsed,
+	})
+}
+
+func UpdateAppLimit(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var limit model.AppLimit
+	err := ctx.BindJSON(&limit)
+	if err != nil {
+		etlog.L().Error("unable to update app limit, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update app limit failed")
+		return
+	}
+
+	if limit.Aid <= 0 || limit.AidRate < 0 || limit.AkRate < 0 || limit.DidRate < 0 ||
+		limit.AidBurst < 0 || limit.AkBurst < 0 || limit.DidBurst < 0 || limit.DailyQuota < 0 || limit.MonthlyQuota < 0 {
+		abortCtx(ctx, http.StatusBadRequest, "invalid app limit")
+		return
+	}
+
+	res := db.Mysql().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "aid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"aid_rate", "aid_burst", "ak_rate", "ak_burst", "did_rate", "did_burst", "daily_quota", "monthly_quota", "updated_at"}),
+	}).Create(&limit)
+	if res.Error != nil {
+		etlog.L().Error("update app limit failed", zap.Int("aid", limit.Aid), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	invalidateLimit(limit.Aid)
+
+	etlog.L().Info("updated app limit", zap.Any("limit", limit), zap.Int("operator_uid", requestUser.Uid))
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
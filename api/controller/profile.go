@@ -0,0 +1,225 @@
+//
+// File: profile.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common/stream"
+	"oset/component/profile"
+	"oset/db"
+	"oset/model"
+	"strconv"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	eventProfileUpdate = "$profile_update"
+)
+
+// profileRequest updates the profile of the user when user_id is set, of the
+// device otherwise.
+type profileRequest struct {
+	Did        model.DeviceID `json:"did"`
+	UserID     string         `json:"user_id"`
+	ClientTime int64          `json:"client_time"`
+	profile.Update
+}
+
+func (req *profileRequest) target() (kind string, key string) {
+	if req.UserID != "" {
+		return model.PROFILE_KIND_USER, req.UserID
+	}
+
+	return model.PROFILE_KIND_DEVICE, string(req.Did)
+}
+
+// saveProfile applies an update to a stored profile, creating it when it does
+// not exist yet. Updates of the same profile are serialized by a row lock.
+func saveProfile(aid int, kind string, key string, u *profile.Update) (changed map[string]interface{}, unset []string, err error) {
+	err = db.Mysql().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Profile{
+			Aid:        aid,
+			Kind:       kind,
+			Key:        key,
+			Properties: "{}",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var p model.Profile
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("aid = ? AND kind = ? AND profile_key = ?", aid, kind, key).First(&p).Error
+		if err != nil {
+			return err
+		}
+
+		props, err := profile.Decode(p.Properties)
+		if err != nil {
+			return err
+		}
+
+		changed, unset, err = profile.Apply(props, u)
+		if err != nil || len(changed)+len(unset) == 0 {
+			return err
+		}
+
+		properties, err := profile.Encode(props)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&p).Update("properties", properties).Error
+	})
+
+	return
+}
+
+// isProfileError reports whether err is caused by the update rather than by
+// the database.
+func isProfileError(err error) bool {
+	return errors.Is(err, profile.ErrNotNumber) || errors.Is(err, profile.ErrNotList) ||
+		errors.Is(err, profile.ErrTooLarge) || errors.Is(err, profile.ErrInvalidValue)
+}
+
+func UpdateProfile(ctx *gin.Context) {
+	rc, ok := identityContext(ctx)
+	if !ok {
+		return
+	}
+
+	body, err := stream.GetRawBody(ctx)
+	if err != nil {
+		etlog.L().Warn("unable to update profile, because read body failed", zap.Int("aid", rc.aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "read body error")
+		return
+	}
+
+	var req profileRequest
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	err = d.Decode(&req)
+	if err != nil {
+		etlog.L().Warn("unable to update profile, because decode body failed", zap.Int("aid", rc.aid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid profile request")
+		return
+	}
+
+	if req.Did == "" && req.UserID == "" {
+		abortCtx(ctx, http.StatusBadRequest, "did or user_id is required")
+		return
+	}
+
+	if err = checkIdentity(req.Did, req.UserID); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = req.Check(); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the call writes one event, which is not deduplicated or sampled
+	_, err = limitReport(ctx, rc, []string{string(req.Did)})
+	if err == nil {
+		err = countQuota(rc.aid, rc.receivedAt, 1)
+	}
+	if err != nil {
+		abortLimited(ctx, err)
+		etlog.L().Warn("unable to update profile, because it is over a limit", zap.Int("aid", rc.aid), zap.Error(err))
+		return
+	}
+
+	kind, key := req.target()
+	changed, unset, err := saveProfile(rc.aid, kind, key, &req.Update)
+	if isProfileError(err) {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		etlog.L().Error("failed to save profile", zap.Int("aid", rc.aid), zap.String("kind", kind), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "update profile failed")
+		return
+	}
+
+	n := len(changed) + len(unset)
+	if n > 0 {
+		// changed properties keep their names so that redaction rules apply
+		data := changed
+		data["$profile"] = kind
+		if len(unset) > 0 {
+			data["$unset"] = unset
+		}
+
+		err = emitEvent(rc, model.Event{
+			Did:        req.Did,
+			UserID:     req.UserID,
+			Event:      eventProfileUpdate,
+			ClientTime: req.ClientTime,
+		}, data)
+		if err != nil {
+			abortCtx(ctx, http.StatusInternalServerError, "update profile failed")
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"changed": n,
+	})
+}
+
+func GetProfile(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get profile failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	kind, key := model.PROFILE_KIND_USER, ctx.Query("user_id")
+	if key == "" {
+		kind, key = model.PROFILE_KIND_DEVICE, ctx.Query("did")
+	}
+	if key == "" {
+		abortCtx(ctx, http.StatusBadRequest, "did or user_id is required")
+		return
+	}
+
+	var p model.Profile
+	res := db.Mysql().Where("aid = ? AND kind = ? AND profile_key = ?", aid, kind, key).First(&p)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		abortCtx(ctx, http.StatusNotFound, "the profile does not exist")
+		return
+	} else if res.Error != nil {
+		etlog.L().Error("failed to get profile", zap.Int("aid", aid), zap.String("kind", kind), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(p)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"profile": string(jsonBytes),
+	})
+}
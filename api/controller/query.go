@@ -0,0 +1,60 @@
+//
+// File: query.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/component/store"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QueryEvents returns a page of the events kept in the built-in event store.
+func QueryEvents(ctx *gin.Context) {
+	var q store.Query
+	err := ctx.BindJSON(&q)
+	if err != nil {
+		etlog.L().Warn("unable to query events, because bindjson failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid query")
+		return
+	}
+
+	if err = q.Check(); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := store.Run(&q)
+	if errors.Is(err, store.ErrDisabled) {
+		abortCtx(ctx, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		etlog.L().Error("failed to query events", zap.Int("aid", q.Aid), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "query events failed")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(page.Events)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":         "success",
+		"event_list":  string(jsonBytes),
+		"next_cursor": page.Next,
+	})
+}
@@ -0,0 +1,331 @@
+//
+// File: redaction.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"oset/common"
+	"oset/component/redaction"
+	"oset/db"
+	"oset/model"
+	"strconv"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// logged in place of a body that could not be redacted
+	withheldBody = "[withheld]"
+)
+
+var (
+	ErrRedactionUnavailable = errors.New("redaction rules are unavailable")
+)
+
+// redactEvent applies the redaction rules of the app to the data of an event.
+// Without its rules an event is not let out, it could carry personal data.
+func redactEvent(rc *reportContext, event *model.Event) error {
+	data, err := redaction.Apply(rc.aid, event.Data)
+	if err != nil {
+		etlog.L().Error("failed to load redaction rules", zap.Int("aid", rc.aid), zap.String("event", event.Event), zap.Error(err))
+		return fmt.Errorf("%w: %s", ErrRedactionUnavailable, err.Error())
+	}
+
+	event.Data = data
+	return nil
+}
+
+// fields of a report body that hold data the rules apply to: the data of an
+// event and the properties of a profile update
+var redactedBodyFields = map[string]bool{
+	"data":       true,
+	"$set":       true,
+	"$set_once":  true,
+	"$increment": true,
+	"$append":    true,
+}
+
+// setLogBody hands the request logger a body it may write out in place of the
+// one that was received. The body is redacted the way its events are on their
+// way to the sinks, it is withheld when the rules of the app are unavailable.
+func setLogBody(ctx *gin.Context, aid int, body []byte) {
+	redacted, err := redactBody(aid, body)
+	if err != nil {
+		etlog.L().Warn("withheld request body from the log", zap.Int("aid", aid), zap.Error(err))
+		redacted = withheldBody
+	}
+
+	ctx.Set("log_body", redacted)
+}
+
+// redactBody redacts a single report, a json array of them or one per line.
+func redactBody(aid int, body []byte) (string, error) {
+	body = bytes.TrimSpace(body)
+
+	if json.Valid(body) {
+		var raws []json.RawMessage
+		if body[0] != '[' || json.Unmarshal(body, &raws) != nil {
+			return redactObject(aid, body)
+		}
+
+		items := make([]json.RawMessage, len(raws))
+		for i, raw := range raws {
+			item, err := redactObject(aid, raw)
+			if err != nil {
+				return "", err
+			}
+			items[i] = json.RawMessage(item)
+		}
+
+		b, err := json.Marshal(items)
+		return string(b), err
+	}
+
+	lines := bytes.Split(body, []byte("\n"))
+	for i := range lines {
+		line, err := redactObject(aid, bytes.TrimSpace(lines[i]))
+		if err != nil {
+			return "", err
+		}
+		lines[i] = []byte(line)
+	}
+
+	return string(bytes.Join(lines, []byte("\n"))), nil
+}
+
+// redactObject redacts one report. The fields holding its data go through the
+// rules, every other field and whatever can not be parsed through the detector
+// rules.
+func redactObject(aid int, raw []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if len(raw) == 0 || json.Unmarshal(raw, &fields) != nil {
+		return redaction.Text(aid, string(raw)), nil
+	}
+
+	for name, v := range fields {
+		if !redactedBodyFields[name] {
+			fields[name] = redactText(aid, v)
+			continue
+		}
+
+		// event data is usually json within a string
+		var data string
+		quoted := json.Unmarshal(v, &data) == nil
+		if !quoted {
+			data = string(v)
+		}
+
+		redacted, err := redaction.Apply(aid, data)
+		if err != nil {
+			return "", err
+		}
+
+		if quoted {
+			// data that is not json is left as it was
+			if redacted == data {
+				redacted = redaction.Text(aid, redacted)
+			}
+			v, _ = json.Marshal(redacted)
+		} else {
+			v = json.RawMessage(redacted)
+		}
+		fields[name] = v
+	}
+
+	b, err := json.Marshal(fields)
+	return string(b), err
+}
+
+// redactText applies the detector rules to a json value, which is kept as a
+// string should that leave it invalid.
+func redactText(aid int, v json.RawMessage) json.RawMessage {
+	redacted := redaction.Text(aid, string(v))
+	if json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+
+	b, _ := json.Marshal(redacted)
+	return b
+}
+
+func GetRedactionRuleList(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get redaction rule list failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	var ruleList []model.RedactionRule
+	res := db.Mysql().Where("aid = ?", aid).Order("id").Find(&ruleList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get redaction rule list", zap.Int("aid", aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(ruleList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":       "success",
+		"rule_list": string(jsonBytes),
+	})
+}
+
+func CreateRedactionRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var rule model.RedactionRule
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to create redaction rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "create redaction rule failed")
+		return
+	}
+
+	if err = redaction.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var app model.App
+	res := db.Mysql().Where("aid = ?", rule.Aid).First(&app)
+	if res.Error != nil {
+		etlog.L().Error("create redaction rule failed", zap.Int("aid", rule.Aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the app does not exist")
+		return
+	}
+
+	rule.ID = 0
+	res = db.Mysql().Create(&rule)
+	if res.Error != nil {
+		etlog.L().Error("unable to create redaction rule", zap.Int("aid", rule.Aid), zap.String("path", rule.Path), zap.String("detector", rule.Detector), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "failed to create redaction rule, "+res.Error.Error())
+		return
+	}
+	redaction.Invalidate(rule.Aid)
+
+	etlog.L().Info("created redaction rule", zap.Int("aid", rule.Aid), zap.String("path", rule.Path), zap.String("detector", rule.Detector), zap.String("action", rule.Action), zap.Int("operator_uid", requestUser.Uid))
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+		"id":   rule.ID,
+	})
+}
+
+func UpdateRedactionRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var rule model.RedactionRule
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to update redaction rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update redaction rule failed")
+		return
+	}
+
+	if err = redaction.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var origin model.RedactionRule
+	res := db.Mysql().Where("id = ?", rule.ID).First(&origin)
+	if res.Error != nil {
+		etlog.L().Error("update redaction rule failed", zap.Int("id", rule.ID), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the redaction rule does not exist")
+		return
+	}
+
+	res = db.Mysql().Model(&model.RedactionRule{}).Where("id = ?", rule.ID).Updates(map[string]interface{}{
+		"path":        rule.Path,
+		"detector":    rule.Detector,
+		"action":      rule.Action,
+		"description": rule.Description,
+	})
+	if res.Error != nil {
+		etlog.L().Error("update redaction rule failed", zap.Int("id", rule.ID), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	redaction.Invalidate(origin.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+func DropRedactionRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		etlog.L().Error("delete redaction rule failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var rule model.RedactionRule
+	res := db.Mysql().Where("id = ?", id).First(&rule)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			abortCtx(ctx, http.StatusOK, "the redaction rule does not exist")
+			return
+		}
+
+		etlog.L().Error("delete redaction rule failed", zap.Int("id", id), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	res = db.Mysql().Delete(&model.RedactionRule{}, id)
+	if res.Error != nil {
+		etlog.L().Error("delete redaction rule failed", zap.Int("id", id), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	redaction.Invalidate(rule.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
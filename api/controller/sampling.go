@@ -0,0 +1,218 @@
+//
+// File: sampling.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common"
+	"oset/component/sampling"
+	"oset/db"
+	"oset/model"
+	"strconv"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSampledOut is returned by prepareEvent for events dropped by the
+	// sampling rules of their app. It is not a rejection.
+	ErrSampledOut = errors.New("event is sampled out")
+)
+
+// sampleEvent applies the sampling rules of the app to an event and stamps the
+// rate it was kept at, so that counts downstream can be weighted by 1/rate.
+func sampleEvent(rc *reportContext, event *model.Event) bool {
+	keep, rate, err := sampling.Sample(rc.aid, event.Event, string(event.Did))
+	if err != nil {
+		// the rules being unavailable must not stop ingestion
+		etlog.L().Error("failed to load sampling rules", zap.Int("aid", rc.aid), zap.String("event", event.Event), zap.Error(err))
+		return true
+	}
+
+	if rate < 1 {
+		event.SampleRate = rate
+	}
+
+	return keep
+}
+
+func GetSamplingRuleList(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get sampling rule list failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	var ruleList []model.SamplingRule
+	res := db.Mysql().Where("aid = ?", aid).Order("priority desc, id").Find(&ruleList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get sampling rule list", zap.Int("aid", aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(ruleList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":       "success",
+		"rule_list": string(jsonBytes),
+	})
+}
+
+func CreateSamplingRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var rule model.SamplingRule
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to create sampling rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "create sampling rule failed")
+		return
+	}
+
+	if err = sampling.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var app model.App
+	res := db.Mysql().Where("aid = ?", rule.Aid).First(&app)
+	if res.Error != nil {
+		etlog.L().Error("create sampling rule failed", zap.Int("aid", rule.Aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the app does not exist")
+		return
+	}
+
+	rule.ID = 0
+	res = db.Mysql().Create(&rule)
+	if res.Error != nil {
+		etlog.L().Error("unable to create sampling rule", zap.Int("aid", rule.Aid), zap.String("pattern", rule.Pattern), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "failed to create sampling rule, "+res.Error.Error())
+		return
+	}
+	sampling.Invalidate(rule.Aid)
+
+	etlog.L().Info("created sampling rule", zap.Int("aid", rule.Aid), zap.String("pattern", rule.Pattern), zap.Float64("rate", rule.Rate), zap.Int("operator_uid", requestUser.Uid))
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+		"id":   rule.ID,
+	})
+}
+
+func UpdateSamplingRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var rule model.SamplingRule
+	err := ctx.BindJSON(&rule)
+	if err != nil {
+		etlog.L().Error("unable to update sampling rule, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update sampling rule failed")
+		return
+	}
+
+	if err = sampling.Check(&rule); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var origin model.SamplingRule
+	res := db.Mysql().Where("id = ?", rule.ID).First(&origin)
+	if res.Error != nil {
+		etlog.L().Error("update sampling rule failed", zap.Int("id", rule.ID), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the sampling rule does not exist")
+		return
+	}
+
+	res = db.Mysql().Model(&model.SamplingRule{}).Where("id = ?", rule.ID).Updates(map[string]interface{}{
+		"pattern":     rule.Pattern,
+		"rate":        rule.Rate,
+		"by_device":   rule.ByDevice,
+		"priority":    rule.Priority,
+		"description": rule.Description,
+	})
+	if res.Error != nil {
+		etlog.L().Error("update sampling rule failed", zap.Int("id", rule.ID), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	sampling.Invalidate(origin.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+func DropSamplingRule(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		etlog.L().Error("delete sampling rule failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var rule model.SamplingRule
+	res := db.Mysql().Where("id = ?", id).First(&rule)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			abortCtx(ctx, http.StatusOK, "the sampling rule does not exist")
+			return
+		}
+
+		etlog.L().Error("delete sampling rule failed", zap.Int("id", id), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	res = db.Mysql().Delete(&model.SamplingRule{}, id)
+	if res.Error != nil {
+		etlog.L().Error("delete sampling rule failed", zap.Int("id", id), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	sampling.Invalidate(rule.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
@@ -0,0 +1,258 @@
+//
+// File: schema.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oset/common"
+	"oset/component/schema"
+	"oset/db"
+	"oset/model"
+	"strconv"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func checkEventSchema(es *model.EventSchema) string {
+	if es.Event == "" {
+		return "event name is empty"
+	}
+
+	if es.Status == "" {
+		es.Status = model.SCHEMA_STATUS_ACTIVE
+	}
+	if es.Status != model.SCHEMA_STATUS_ACTIVE && es.Status != model.SCHEMA_STATUS_DEPRECATED {
+		return "invalid status, must be active or deprecated"
+	}
+
+	if _, err := schema.Compile(es.Schema); err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+func GetEventSchemaList(ctx *gin.Context) {
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get event schema list failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	var schemaList []model.EventSchema
+	res := db.Mysql().Where("aid = ?", aid).Find(&schemaList)
+	if res.Error != nil {
+		etlog.L().Error("failed to get event schema list", zap.Int("aid", aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(schemaList)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":         "success",
+		"schema_list": string(jsonBytes),
+	})
+}
+
+func GetEventSchema(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		etlog.L().Error("get event schema failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var es model.EventSchema
+	res := db.Mysql().Where("id = ?", id).First(&es)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusOK, gin.H{
+				"code": common.StatusCommonOK,
+				"data": "{}",
+				"msg":  "the event schema does not exist",
+			})
+			return
+		}
+
+		etlog.L().Error("search event schema error", zap.Int("id", id), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(es)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+		"data": string(jsonBytes),
+	})
+}
+
+func CreateEventSchema(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var es model.EventSchema
+	err := ctx.BindJSON(&es)
+	if err != nil {
+		etlog.L().Error("unable to create event schema, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "create event schema failed")
+		return
+	}
+
+	if msg := checkEventSchema(&es); msg != "" {
+		abortCtx(ctx, http.StatusBadRequest, msg)
+		return
+	}
+
+	var app model.App
+	res := db.Mysql().Where("aid = ?", es.Aid).First(&app)
+	if res.Error != nil {
+		etlog.L().Error("create event schema failed", zap.Int("aid", es.Aid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the app does not exist")
+		return
+	}
+
+	es.ID = 0
+	es.Version = 1
+	res = db.Mysql().Create(&es)
+	if res.Error != nil {
+		etlog.L().Error("unable to create event schema", zap.Int("aid", es.Aid), zap.String("event", es.Event), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "failed to create event schema, "+res.Error.Error())
+		return
+	}
+	schema.Invalidate(es.Aid)
+
+	etlog.L().Info("created event schema", zap.Int("aid", es.Aid), zap.String("event", es.Event), zap.Int("operator_uid", requestUser.Uid))
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+		"id":   es.ID,
+	})
+}
+
+func UpdateEventSchema(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	var es model.EventSchema
+	err := ctx.BindJSON(&es)
+	if err != nil {
+		etlog.L().Error("unable to update event schema, because bindjson failed", zap.Int("operator_uid", requestUser.Uid), zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "update event schema failed")
+		return
+	}
+
+	var origin model.EventSchema
+	res := db.Mysql().Where("id = ?", es.ID).First(&origin)
+	if res.Error != nil {
+		etlog.L().Error("update event schema failed", zap.Int("id", es.ID), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusBadRequest, "the event schema does not exist")
+		return
+	}
+
+	// the app and event name identify a definition and can not be changed
+	es.Aid = origin.Aid
+	es.Event = origin.Event
+	if msg := checkEventSchema(&es); msg != "" {
+		abortCtx(ctx, http.StatusBadRequest, msg)
+		return
+	}
+
+	required, _ := json.Marshal(es.Required)
+	res = db.Mysql().Model(&model.EventSchema{}).Where("id = ?", es.ID).Updates(map[string]interface{}{
+		"schema":      es.Schema,
+		"required":    string(required),
+		"status":      es.Status,
+		"description": es.Description,
+		"version":     gorm.Expr("version + 1"),
+	})
+	if res.Error != nil {
+		etlog.L().Error("update event schema failed", zap.Int("id", es.ID), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	schema.Invalidate(origin.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
+
+func DropEventSchema(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Query("id"))
+	if err != nil {
+		etlog.L().Error("delete event schema failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var es model.EventSchema
+	res := db.Mysql().Where("id = ?", id).First(&es)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			abortCtx(ctx, http.StatusOK, "the event schema does not exist")
+			return
+		}
+
+		etlog.L().Error("delete event schema failed", zap.Int("id", id), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	res = db.Mysql().Delete(&model.EventSchema{}, id)
+	if res.Error != nil {
+		etlog.L().Error("delete event schema failed", zap.Int("id", id), zap.Int("uid", requestUser.Uid), zap.Error(res.Error))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+	schema.Invalidate(es.Aid)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": common.StatusCommonOK,
+		"msg":  "success",
+	})
+}
@@ -0,0 +1,192 @@
+//
+// File: session.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"oset/component/session"
+	"oset/component/sink"
+	"oset/db"
+	"oset/model"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	eventSessionStart = "$session_start"
+	eventSessionEnd   = "$session_end"
+)
+
+const (
+	defaultSessionGap           = 30 * time.Minute
+	defaultSessionSweepInterval = 10 * time.Second
+	sessionSweepBatch           = 500
+)
+
+var (
+	sessionStop chan struct{}
+)
+
+func sessionGap() time.Duration {
+	gap := viper.GetDuration("session.gap")
+	if gap <= 0 {
+		gap = defaultSessionGap
+	}
+
+	return gap
+}
+
+func sessionStartEvent(first *model.Event) model.Event {
+	return model.Event{
+		Aid:        first.Aid,
+		Did:        first.Did,
+		UserID:     first.UserID,
+		Event:      eventSessionStart,
+		Data:       "{}",
+		Time:       first.Time,
+		ServerTime: first.ServerTime,
+		Geo:        first.Geo,
+		UA:         first.UA,
+		SessionID:  first.SessionID,
+	}
+}
+
+func sessionEndEvent(s *session.Session) model.Event {
+	data, _ := json.Marshal(map[string]interface{}{
+		"duration": s.Last.Sub(s.Start).Milliseconds(),
+		"events":   s.Events,
+	})
+
+	return model.Event{
+		Aid:        s.Aid,
+		Did:        model.DeviceID(s.Did),
+		Event:      eventSessionEnd,
+		Data:       string(data),
+		Time:       s.Last,
+		ServerTime: time.Now(),
+		SessionID:  s.ID,
+	}
+}
+
+// sessionAssignment is the session an event was put into, kept until the
+// event is written.
+type sessionAssignment struct {
+	aid int
+	did string
+	a   session.Assignment
+}
+
+// sessionize puts events into the sessions of their devices and returns them
+// in order, together with the session events that this started or ended, and
+// the assignments made. Events without a device, late events and events seen
+// while redis is unavailable are left without a session.
+func sessionize(events []model.Event) ([]model.Event, []sessionAssignment) {
+	if !viper.GetBool("session.enabled") {
+		return events, nil
+	}
+
+	gap := sessionGap()
+	rctx := context.Background()
+	out := make([]model.Event, 0, len(events))
+	var assigned []sessionAssignment
+	for i := range events {
+		if events[i].Did == "" {
+			out = append(out, events[i])
+			continue
+		}
+
+		a, err := session.Assign(rctx, db.Redis(), events[i].Aid, string(events[i].Did), events[i].Time, gap)
+		if err != nil {
+			etlog.L().Warn("failed to assign event to a session", zap.Int("aid", events[i].Aid), zap.String("did", string(events[i].Did)), zap.Error(err))
+			out = append(out, events[i])
+			continue
+		} else if a.ID == "" {
+			out = append(out, events[i])
+			continue
+		}
+		assigned = append(assigned, sessionAssignment{aid: events[i].Aid, did: string(events[i].Did), a: a})
+
+		if a.Ended != nil {
+			out = append(out, sessionEndEvent(a.Ended))
+		}
+
+		events[i].SessionID = a.ID
+		if a.Started {
+			out = append(out, sessionStartEvent(&events[i]))
+		}
+		out = append(out, events[i])
+	}
+
+	return out, assigned
+}
+
+// releaseSessions undoes the assignments of events that were not written, so
+// that a retry of them finds the sessions as they were.
+func releaseSessions(assigned []sessionAssignment) {
+	gap := sessionGap()
+	rctx := context.Background()
+	for i := len(assigned) - 1; i >= 0; i-- {
+		err := session.Release(rctx, db.Redis(), assigned[i].aid, assigned[i].did, assigned[i].a, gap)
+		if err != nil {
+			etlog.L().Warn("failed to release session assignment", zap.Int("aid", assigned[i].aid), zap.String("did", assigned[i].did), zap.Error(err))
+		}
+	}
+}
+
+// runSessionSweeper ends the sessions that have been idle for the gap. Every
+// proxy instance runs one, each idle session is ended by only one of them.
+func runSessionSweeper(stop chan struct{}) {
+	interval := viper.GetDuration("session.sweep_interval")
+	if interval <= 0 {
+		interval = defaultSessionSweepInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			ended, err := session.Sweep(context.Background(), db.Redis(), sessionGap(), sessionSweepBatch)
+			if err != nil {
+				etlog.L().Warn("failed to sweep idle sessions", zap.Error(err))
+			}
+
+			if len(ended) == 0 {
+				break
+			}
+
+			events := make([]model.Event, 0, len(ended))
+			for _, s := range ended {
+				events = append(events, sessionEndEvent(s))
+			}
+
+			if err = sink.Write(events); err != nil {
+				etlog.L().Error("failed to write session end events", zap.Int("size", len(events)), zap.Error(err))
+				break
+			}
+			sendRealtime(events)
+			recordStats(events)
+
+			if len(ended) < sessionSweepBatch {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//
+// File: spool.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"net/http"
+	"oset/component/sink"
+	"oset/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpoolInfo describes the spool of one event sink.
+type SpoolInfo struct {
+	Depth      int64   `json:"depth"`
+	Bytes      int64   `json:"bytes"`
+	Segments   int     `json:"segments"`
+	Oldest     int64   `json:"oldest"`
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+func GetSpoolStats(ctx *gin.Context) {
+	ru, _ := ctx.Get("user")
+	requestUser := ru.(model.User)
+
+	if requestUser.Level < model.USERLEVEL_ADMIN {
+		abortCtx(ctx, http.StatusUnauthorized, "权限不足")
+		return
+	}
+
+	spools := make(map[string]SpoolInfo)
+	for name, stats := range sink.SpoolStats() {
+		info := SpoolInfo{
+			Depth:    stats.Depth,
+			Bytes:    stats.Bytes,
+			Segments: stats.Segments,
+		}
+
+		if !stats.Oldest.IsZero() {
+			info.Oldest = stats.Oldest.Unix()
+			info.AgeSeconds = time.Since(stats.Oldest).Seconds()
+		}
+
+		spools[name] = info
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":    "success",
+		"spools": spools,
+	})
+}
@@ -0,0 +1,161 @@
+//
+// File: stats.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"oset/component/stats"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var defaultStatsTTL = map[string]time.Duration{
+	stats.GranularityMinute: 48 * time.Hour,
+	stats.GranularityHour:   60 * 24 * time.Hour,
+	stats.GranularityDay:    400 * 24 * time.Hour,
+}
+
+var (
+	// statsConfig is nil while stats are disabled
+	statsConfig *stats.Config
+)
+
+func InitStats() {
+	if !viper.GetBool("stats.enabled") {
+		return
+	}
+
+	loc := time.Local
+	if name := viper.GetString("stats.timezone"); name != "" {
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			etlog.L().Panic("invalid stats timezone", zap.String("timezone", name), zap.Error(err))
+		}
+		loc = l
+	}
+
+	cfg := &stats.Config{
+		Dimensions: viper.GetStringSlice("stats.dimensions"),
+		TTL:        make(map[string]time.Duration),
+		Location:   loc,
+	}
+
+	for _, granularity := range stats.Granularities {
+		ttl := viper.GetDuration("stats.ttl." + granularity)
+		if ttl <= 0 {
+			ttl = defaultStatsTTL[granularity]
+		}
+		cfg.TTL[granularity] = ttl
+	}
+
+	statsConfig = cfg
+}
+
+// recordStats counts events that were written to the sinks. Counting is best
+// effort, the events are out already.
+func recordStats(events []model.Event) {
+	if statsConfig == nil || len(events) == 0 {
+		return
+	}
+
+	err := stats.Record(context.Background(), db.Redis(), statsConfig, events)
+	if err != nil {
+		etlog.L().Warn("failed to record event stats", zap.Int("size", len(events)), zap.Error(err))
+	}
+}
+
+// recordSeen marks the devices of the events of a report as active, and counts
+// the unique devices of those dropped by sampling. Devices are seen whether or
+// not their events are kept.
+func recordSeen(rc *reportContext) {
+	recordActive(rc.seen)
+
+	if statsConfig == nil || len(rc.sampledOut) == 0 {
+		return
+	}
+
+	err := stats.RecordDevices(context.Background(), db.Redis(), statsConfig, rc.sampledOut)
+	if err != nil {
+		etlog.L().Warn("failed to record devices of sampled out events", zap.Int("size", len(rc.sampledOut)), zap.Error(err))
+	}
+}
+
+// parseStatsTime accepts rfc3339 or unix seconds.
+func parseStatsTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+func GetStats(ctx *gin.Context) {
+	if statsConfig == nil {
+		abortCtx(ctx, http.StatusServiceUnavailable, "stats are not enabled")
+		return
+	}
+
+	aid, err := strconv.Atoi(ctx.Query("aid"))
+	if err != nil {
+		etlog.L().Error("get stats failed", zap.Error(err))
+		abortCtx(ctx, http.StatusBadRequest, "invalid aid")
+		return
+	}
+
+	from, ferr := parseStatsTime(ctx.Query("from"))
+	to, terr := parseStatsTime(ctx.DefaultQuery("to", strconv.FormatInt(time.Now().Unix(), 10)))
+	if ferr != nil || terr != nil {
+		abortCtx(ctx, http.StatusBadRequest, "invalid time range")
+		return
+	}
+
+	q := stats.Query{
+		Aid:         aid,
+		Event:       ctx.DefaultQuery("event", stats.AllEvents),
+		Metric:      ctx.DefaultQuery("metric", stats.MetricCount),
+		Granularity: ctx.DefaultQuery("granularity", stats.GranularityHour),
+		From:        from,
+		To:          to,
+		GroupBy:     ctx.Query("group_by"),
+	}
+
+	if err = q.Check(statsConfig); err != nil {
+		abortCtx(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	series, err := stats.Run(ctx, db.Redis(), statsConfig, &q)
+	if err != nil {
+		etlog.L().Error("failed to get stats", zap.Int("aid", aid), zap.String("event", q.Event), zap.Error(err))
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(series)
+	if err != nil {
+		etlog.L().Error(err.Error())
+		abortCtx(ctx, http.StatusInternalServerError, "unknown error")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":    "success",
+		"series": string(jsonBytes),
+	})
+}
@@ -0,0 +1,133 @@
+//
+// File: encoding.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMaxBodySize = 10 << 20
+
+	// the largest window a zstd frame may ask the decoder to allocate
+	maxZstdWindow = 8 << 20
+)
+
+var (
+	ErrBodyTooLarge        = errors.New("body exceeds the size limit")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+func maxBodySize() int64 {
+	size := viper.GetInt64("event.max_body_size")
+	if size <= 0 {
+		size = defaultMaxBodySize
+	}
+
+	return size
+}
+
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but plenty of clients send raw deflate
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+}
+
+func decode(raw []byte, encodings []string, limit int64) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(raw)
+
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newDecoder(encodings[i], reader)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+
+		reader = decoder
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	return body, nil
+}
+
+// DecodeBody replaces a request body sent with a Content-Encoding by its
+// decompressed content. Both the body as sent and its content are refused when
+// larger than event.max_body_size. On failure the original body is left in place.
+func DecodeBody(ctx *gin.Context) error {
+	raw, err := readBody(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(raw))
+
+	header := ctx.GetHeader("Content-Encoding")
+	if header == "" {
+		return nil
+	}
+
+	var encodings []string
+	for _, e := range strings.Split(header, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e != "" && e != "identity" {
+			encodings = append(encodings, e)
+		}
+	}
+
+	body, err := decode(raw, encodings, maxBodySize())
+	if err != nil {
+		ctx.Request.Body = io.NopCloser(bytes.NewBuffer(raw))
+		return err
+	}
+
+	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	ctx.Request.ContentLength = int64(len(body))
+	ctx.Request.Header.Del("Content-Encoding")
+	ctx.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
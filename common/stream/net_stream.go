@@ -16,12 +16,32 @@
 	"github.com/gin-gonic/gin"
 )
 
+// GetRawBody reads the request body and puts it back for the handlers. The
+// body is read as sent, decompression is left to DecompressMiddleware, and
+// bodies larger than event.max_body_size are refused with ErrBodyTooLarge.
 func GetRawBody(ctx *gin.Context) (bodyBytes []byte, err error) {
-	bodyBytes, err = io.ReadAll(ctx.Request.Body)
+	bodyBytes, err = readBody(ctx)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	return
 }
+
+// readBody reads at most event.max_body_size bytes of the request body. A
+// larger body is put back whole, so that later readers fail the same way.
+func readBody(ctx *gin.Context) ([]byte, error) {
+	limit := maxBodySize()
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		ctx.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), ctx.Request.Body))
+		return nil, ErrBodyTooLarge
+	}
+
+	return body, nil
+}
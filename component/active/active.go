@@ -0,0 +1,90 @@
+//
+// File: active.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package active tracks when each device of an app was last seen, in a redis
+// sorted set per app scored by unix milliseconds, to count the devices active
+// within a recent window.
+package active
+
+import (
+	"context"
+	"oset/model"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MaxWindow is the longest window devices are counted over, devices not seen
+// within it are dropped from the set.
+const MaxWindow = 30 * time.Minute
+
+func key(aid int) string {
+	return "active:" + strconv.Itoa(aid)
+}
+
+// Touch marks the devices of events as seen when the events were received.
+func Touch(ctx context.Context, rdb redis.Cmdable, events []model.Event) error {
+	now := time.Now()
+	seen := make(map[int][]redis.Z)
+	for i := range events {
+		if events[i].Did == "" {
+			continue
+		}
+
+		at := events[i].ServerTime
+		if at.IsZero() {
+			at = now
+		}
+
+		seen[events[i].Aid] = append(seen[events[i].Aid], redis.Z{
+			Score:  float64(at.UnixMilli()),
+			Member: string(events[i].Did),
+		})
+	}
+
+	if len(seen) == 0 {
+		return nil
+	}
+
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		expired := strconv.FormatInt(now.Add(-MaxWindow).UnixMilli(), 10)
+		for aid, members := range seen {
+			// GT keeps the latest time of a device reported out of order
+			p.ZAddArgs(ctx, key(aid), redis.ZAddArgs{GT: true, Members: members})
+			p.ZRemRangeByScore(ctx, key(aid), "-inf", "("+expired)
+			p.Expire(ctx, key(aid), 2*MaxWindow)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// Count returns how many devices of an app were seen within each window.
+func Count(ctx context.Context, rdb redis.Cmdable, aid int, windows []time.Duration) ([]int64, error) {
+	now := time.Now()
+	cmds := make([]*redis.IntCmd, len(windows))
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, window := range windows {
+			cmds[i] = p.ZCount(ctx, key(aid), strconv.FormatInt(now.Add(-window).UnixMilli(), 10), "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(windows))
+	for i := range cmds {
+		counts[i] = cmds[i].Val()
+	}
+
+	return counts, nil
+}
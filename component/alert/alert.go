@@ -0,0 +1,212 @@
+//
+// File: alert.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package alert evaluates alert rules against the event counters of package
+// stats and delivers their state changes to webhooks.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"oset/component/stats"
+	"oset/model"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MaxWindow        = 24 * time.Hour
+	MaxBaselineWeeks = 8
+
+	defaultBaselineWeeks = 4
+
+	webhookTimeout = 5 * time.Second
+	webhookRetries = 3
+)
+
+var (
+	ErrInvalidKind      = errors.New("kind must be threshold or baseline")
+	ErrInvalidDirection = errors.New("direction must be above or below")
+	ErrInvalidWindow    = fmt.Errorf("window must be within [60, %d] seconds", int(MaxWindow.Seconds()))
+	ErrInvalidBaseline  = fmt.Errorf("deviation must be positive and baseline weeks within [1, %d]", MaxBaselineWeeks)
+	ErrInvalidWebhook   = errors.New("webhook url must be http or https")
+	ErrInvalidEvent     = errors.New("event is required")
+)
+
+var client = &http.Client{
+	Timeout: webhookTimeout,
+}
+
+// Check reports whether a rule can be evaluated, filling in its defaults.
+func Check(rule *model.AlertRule) error {
+	if rule.Event == "" {
+		return ErrInvalidEvent
+	}
+
+	if rule.Kind != model.ALERT_KIND_THRESHOLD && rule.Kind != model.ALERT_KIND_BASELINE {
+		return ErrInvalidKind
+	}
+
+	if rule.Direction != model.ALERT_DIRECTION_ABOVE && rule.Direction != model.ALERT_DIRECTION_BELOW {
+		return ErrInvalidDirection
+	}
+
+	// counts are kept by the minute
+	if rule.Window < 60 || time.Duration(rule.Window)*time.Second > MaxWindow {
+		return ErrInvalidWindow
+	}
+
+	if rule.Kind == model.ALERT_KIND_BASELINE {
+		if rule.BaselineWeeks == 0 {
+			rule.BaselineWeeks = defaultBaselineWeeks
+		}
+		if rule.Deviation <= 0 || rule.BaselineWeeks < 0 || rule.BaselineWeeks > MaxBaselineWeeks {
+			return ErrInvalidBaseline
+		}
+	}
+
+	u, err := url.Parse(rule.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhook
+	}
+
+	return nil
+}
+
+// Result is a rule evaluated at one time. Expected is the threshold or the
+// baseline, Known is false when the baseline is too small to tell.
+type Result struct {
+	Value    float64
+	Expected float64
+	Firing   bool
+	Known    bool
+}
+
+// Evaluate holds the count of the last complete Window of a rule against its
+// threshold or baseline.
+func Evaluate(ctx context.Context, rdb redis.Cmdable, cfg *stats.Config, rule *model.AlertRule, now time.Time) (res Result, err error) {
+	window := time.Duration(rule.Window) * time.Second
+	end := now.Truncate(time.Minute)
+	start := end.Add(-window)
+
+	res.Value, err = stats.Sum(ctx, rdb, cfg, rule.Aid, rule.Event, stats.GranularityMinute, start, end)
+	if err != nil {
+		return
+	}
+
+	if rule.Kind == model.ALERT_KIND_THRESHOLD {
+		res.Expected = rule.Threshold
+		res.Known = true
+		res.Firing = exceeds(rule.Direction, res.Value, rule.Threshold)
+		return
+	}
+
+	// the same span of previous weeks, read from the hour buckets covering it
+	// as minute buckets are not kept that long, and scaled to the window
+	total := 0.0
+	for k := 1; k <= rule.BaselineWeeks; k++ {
+		from := start.AddDate(0, 0, -7*k).Truncate(time.Hour)
+		to := end.AddDate(0, 0, -7*k)
+		if hour := to.Truncate(time.Hour); hour.Before(to) {
+			to = hour.Add(time.Hour)
+		}
+
+		count, err := stats.Sum(ctx, rdb, cfg, rule.Aid, rule.Event, stats.GranularityHour, from, to)
+		if err != nil {
+			return res, err
+		}
+		total += count * window.Hours() / to.Sub(from).Hours()
+	}
+
+	res.Expected = total / float64(rule.BaselineWeeks)
+	if res.Expected <= 0 || res.Expected < rule.MinBaseline {
+		return
+	}
+
+	res.Known = true
+	bound := res.Expected * (1 + rule.Deviation)
+	if rule.Direction == model.ALERT_DIRECTION_BELOW {
+		bound = res.Expected * (1 - rule.Deviation)
+	}
+	res.Firing = exceeds(rule.Direction, res.Value, bound)
+
+	return
+}
+
+func exceeds(direction string, value float64, bound float64) bool {
+	if direction == model.ALERT_DIRECTION_BELOW {
+		return value < bound
+	}
+
+	return value > bound
+}
+
+// Notification is the body posted to the webhook of a rule when its state
+// changes.
+type Notification struct {
+	RuleID   int     `json:"rule_id"`
+	Aid      int     `json:"aid"`
+	Name     string  `json:"name"`
+	Event    string  `json:"event"`
+	Kind     string  `json:"kind"`
+	State    string  `json:"state"`
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"`
+	Window   int     `json:"window"`
+	Time     int64   `json:"time"`
+}
+
+// Notify posts a notification to a webhook, retrying failed deliveries.
+func Notify(ctx context.Context, webhook string, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = post(ctx, webhook, body)
+		if err == nil || attempt+1 >= webhookRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+func post(ctx context.Context, webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return nil
+}
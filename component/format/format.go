@@ -0,0 +1,140 @@
+//
+// File: format.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package format turns event reports sent in a binary format into the json
+// form the ingestion path works on, so that everything after decoding is
+// shared with json reports.
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"oset/model"
+	"strings"
+)
+
+type Format int
+
+const (
+	JSON Format = iota
+	Protobuf
+	MessagePack
+)
+
+var (
+	ErrInvalidEvent = errors.New("invalid event")
+	ErrInvalidBatch = errors.New("invalid event batch")
+)
+
+func (f Format) String() string {
+	switch f {
+	case Protobuf:
+		return "protobuf"
+	case MessagePack:
+		return "msgpack"
+	}
+
+	return "json"
+}
+
+// Binary reports whether the body needs transcoding before it is bound.
+func (f Format) Binary() bool {
+	return f != JSON
+}
+
+// Parse picks the format of a body from its Content-Type. Anything that is not
+// a known binary type is read as json, which is what clients always sent.
+func Parse(contentType string) Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	switch mediaType {
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return Protobuf
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return MessagePack
+	}
+
+	return JSON
+}
+
+// Transcode converts a body holding a single event to json.
+func Transcode(f Format, body []byte) ([]byte, error) {
+	var (
+		event model.Event
+		err   error
+	)
+
+	switch f {
+	case Protobuf:
+		event, err = decodeProtoEvent(body)
+	case MessagePack:
+		event, err = decodeMsgpackEvent(body)
+	default:
+		return body, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(event)
+}
+
+// TranscodeBatch converts a body holding a batch of events to a json array.
+// A batch that cannot be decoded as a whole is rejected as a whole.
+func TranscodeBatch(f Format, body []byte) ([]byte, error) {
+	if !f.Binary() {
+		return body, nil
+	}
+
+	events, err := decodeBatch(f, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(events)
+}
+
+// TranscodeAny converts a body holding either a single event or a batch, for
+// bodies kept without the endpoint they were sent to.
+func TranscodeAny(f Format, body []byte) ([]byte, error) {
+	// a single protobuf event reads as an empty batch, never the other way round
+	if events, err := decodeBatch(f, body); err == nil && len(events) > 0 {
+		return json.Marshal(events)
+	}
+
+	return Transcode(f, body)
+}
+
+func decodeBatch(f Format, body []byte) ([]model.Event, error) {
+	if f == Protobuf {
+		return decodeProtoBatch(body)
+	}
+
+	return decodeMsgpackBatch(body)
+}
+
+// dataJSON turns structured event properties into the json string kept in
+// Event.Data. Binary formats may leave them out for events without properties.
+func dataJSON(data map[string]interface{}) (string, error) {
+	if data == nil {
+		return "{}", nil
+	}
+
+	jdata, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jdata), nil
+}
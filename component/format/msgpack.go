@@ -0,0 +1,107 @@
+//
+// File: msgpack.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"oset/model"
+	"reflect"
+	"strconv"
+
+	"github.com/ugorji/go/codec"
+)
+
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	return h
+}
+
+// msgpackEvent is an event as sent in messagepack. It uses the keys of the json
+// form, but data may be a map instead of a json string.
+type msgpackEvent struct {
+	Did        interface{} `codec:"did"`
+	UserID     string      `codec:"user_id"`
+	Event      string      `codec:"event"`
+	Data       interface{} `codec:"data"`
+	ClientTime int64       `codec:"client_time"`
+	EventID    string      `codec:"event_id"`
+}
+
+func (me *msgpackEvent) toEvent() (event model.Event, err error) {
+	event = model.Event{
+		UserID:     me.UserID,
+		Event:      me.Event,
+		ClientTime: me.ClientTime,
+		EventID:    me.EventID,
+	}
+
+	switch did := me.Did.(type) {
+	case nil:
+	case string:
+		event.Did = model.DeviceID(did)
+	case int64:
+		event.Did = model.NumericDeviceID(json.Number(strconv.FormatInt(did, 10)))
+	case uint64:
+		event.Did = model.NumericDeviceID(json.Number(strconv.FormatUint(did, 10)))
+	default:
+		return event, fmt.Errorf("%w: did must be a string or an integer, got %T", ErrInvalidEvent, did)
+	}
+
+	switch data := me.Data.(type) {
+	case nil:
+		event.Data, err = dataJSON(nil)
+	case string:
+		event.Data = data
+	case map[string]interface{}:
+		event.Data, err = dataJSON(data)
+	default:
+		err = fmt.Errorf("data must be a map or a json string, got %T", data)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidEvent, err.Error())
+	}
+
+	return
+}
+
+func decodeMsgpackEvent(b []byte) (model.Event, error) {
+	var me msgpackEvent
+	err := codec.NewDecoderBytes(b, msgpackHandle).Decode(&me)
+	if err != nil {
+		return model.Event{}, fmt.Errorf("%w: %s", ErrInvalidEvent, err.Error())
+	}
+
+	return me.toEvent()
+}
+
+func decodeMsgpackBatch(b []byte) ([]model.Event, error) {
+	var mes []msgpackEvent
+	err := codec.NewDecoderBytes(b, msgpackHandle).Decode(&mes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBatch, err.Error())
+	}
+
+	events := make([]model.Event, 0, len(mes))
+	for i := range mes {
+		event, err := mes[i].toEvent()
+		if err != nil {
+			return nil, fmt.Errorf("%w: item %d: %s", ErrInvalidBatch, i, err.Error())
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,141 @@
+//
+// File: protobuf.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"oset/model"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// field numbers of proto/event.proto
+const (
+	fieldEventDid        protowire.Number = 1
+	fieldEventName       protowire.Number = 2
+	fieldEventData       protowire.Number = 3
+	fieldEventDataJSON   protowire.Number = 4
+	fieldEventClientTime protowire.Number = 5
+	fieldEventID         protowire.Number = 6
+	fieldEventDeviceID   protowire.Number = 7
+	fieldEventUserID     protowire.Number = 8
+
+	fieldBatchEvents protowire.Number = 1
+)
+
+func decodeProtoEvent(b []byte) (event model.Event, err error) {
+	var (
+		data    map[string]interface{}
+		rawData string
+		hasData bool
+		// a string device_id wins over the numeric did of older clients
+		numDid    int64
+		hasNumDid bool
+	)
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return event, fmt.Errorf("%w: %s", ErrInvalidEvent, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldEventDid && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			numDid, hasNumDid = int64(v), true
+		case num == fieldEventName && typ == protowire.BytesType:
+			event.Event, n = protowire.ConsumeString(b)
+		case num == fieldEventData && typ == protowire.BytesType:
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				var s structpb.Struct
+				if err = proto.Unmarshal(v, &s); err != nil {
+					return event, fmt.Errorf("%w: data: %s", ErrInvalidEvent, err.Error())
+				}
+				data, hasData = s.AsMap(), true
+			}
+		case num == fieldEventDataJSON && typ == protowire.BytesType:
+			rawData, n = protowire.ConsumeString(b)
+		case num == fieldEventClientTime && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			event.ClientTime = int64(v)
+		case num == fieldEventID && typ == protowire.BytesType:
+			event.EventID, n = protowire.ConsumeString(b)
+		case num == fieldEventDeviceID && typ == protowire.BytesType:
+			var v string
+			v, n = protowire.ConsumeString(b)
+			event.Did = model.DeviceID(v)
+		case num == fieldEventUserID && typ == protowire.BytesType:
+			event.UserID, n = protowire.ConsumeString(b)
+		default:
+			// unknown fields are skipped so that the schema can grow
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return event, fmt.Errorf("%w: field %d: %s", ErrInvalidEvent, num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+
+	if event.Did == "" && hasNumDid {
+		event.Did = model.NumericDeviceID(json.Number(strconv.FormatInt(numDid, 10)))
+	}
+
+	if !hasData && rawData != "" {
+		// handed on as is, the data check of the ingestion path reports bad json
+		event.Data = rawData
+		return
+	}
+
+	event.Data, err = dataJSON(data)
+	if err != nil {
+		return event, fmt.Errorf("%w: data: %s", ErrInvalidEvent, err.Error())
+	}
+
+	return
+}
+
+func decodeProtoBatch(b []byte) (events []model.Event, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBatch, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if num == fieldBatchEvents && typ == protowire.BytesType {
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				event, err := decodeProtoEvent(v)
+				if err != nil {
+					return nil, fmt.Errorf("%w: item %d: %s", ErrInvalidBatch, len(events), err.Error())
+				}
+				events = append(events, event)
+			}
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return nil, fmt.Errorf("%w: field %d: %s", ErrInvalidBatch, num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+
+	return
+}
@@ -0,0 +1,178 @@
+//
+// File: geoip.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package geoip
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"oset/model"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotLoaded = errors.New("geoip database is not loaded")
+)
+
+type ipRange struct {
+	start netip.Addr
+	end   netip.Addr
+	geo   model.GeoInfo
+}
+
+// Database is an in memory ip range table.
+type Database struct {
+	ranges []ipRange
+}
+
+var (
+	defaultDB *Database
+	once      sync.Once
+)
+
+// Init loads the database configured by enrich.geoip.path, if any. A missing
+// or broken file only disables geo enrichment.
+func Init() {
+	once.Do(func() {
+		path := viper.GetString("enrich.geoip.path")
+		if path == "" {
+			return
+		}
+
+		db, err := Load(path)
+		if err != nil {
+			etlog.L().Error("failed to load geoip database", zap.String("path", path), zap.Error(err))
+			return
+		}
+
+		defaultDB = db
+		etlog.L().Info("loaded geoip database", zap.String("path", path), zap.Int("ranges", len(db.ranges)))
+	})
+}
+
+// Load reads a csv file of ip ranges laid out like the db-ip city lite
+// database: ip_start, ip_end, continent, country, region, city, ... . Files
+// ending in .gz are decompressed.
+func Load(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	db := &Database{}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 4 {
+			return nil, fmt.Errorf("line %d: expected at least 4 columns", line)
+		}
+
+		start, err := netip.ParseAddr(record[0])
+		if err != nil {
+			// a header line
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		end, err := netip.ParseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rg := ipRange{
+			start: start.Unmap(),
+			end:   end.Unmap(),
+			geo: model.GeoInfo{
+				Continent: record[2],
+				Country:   record[3],
+			},
+		}
+		if len(record) > 4 {
+			rg.geo.Region = record[4]
+		}
+		if len(record) > 5 {
+			rg.geo.City = record[5]
+		}
+
+		db.ranges = append(db.ranges, rg)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+
+	return db, nil
+}
+
+// Lookup returns the location of ip, or false if it is in no known range.
+func (db *Database) Lookup(ip string) (geo model.GeoInfo, ok bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+
+	// the last range starting at or before addr
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return
+	}
+
+	rg := db.ranges[i]
+	if rg.start.BitLen() != addr.BitLen() || rg.end.Less(addr) {
+		return
+	}
+
+	return rg.geo, true
+}
+
+// Lookup resolves ip against the database loaded by Init.
+func Lookup(ip string) (model.GeoInfo, error) {
+	if defaultDB == nil {
+		return model.GeoInfo{}, ErrNotLoaded
+	}
+
+	geo, _ := defaultDB.Lookup(ip)
+	return geo, nil
+}
@@ -0,0 +1,245 @@
+//
+// File: profile.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package profile merges profile updates into the stored properties of a user
+// or a device.
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// maxSize is the size of the column the properties are kept in
+const maxSize = 65535
+
+var (
+	ErrEmptyUpdate  = errors.New("profile update has no operations")
+	ErrConflict     = errors.New("property is used by more than one operation")
+	ErrInvalidName  = errors.New("property name must not be empty")
+	ErrNotNumber    = errors.New("$increment needs a number property and value")
+	ErrNotList      = errors.New("$append needs a list property")
+	ErrTooLarge     = errors.New("profile is too large")
+	ErrInvalidValue = errors.New("invalid profile properties")
+)
+
+// Update is one profile update. $set overwrites properties, $set_once only
+// sets those that are missing, $increment adds to numbers, $append adds to
+// lists and $unset removes properties.
+type Update struct {
+	Set       map[string]interface{} `json:"$set,omitempty"`
+	SetOnce   map[string]interface{} `json:"$set_once,omitempty"`
+	Increment map[string]json.Number `json:"$increment,omitempty"`
+	Append    map[string]interface{} `json:"$append,omitempty"`
+	Unset     []string               `json:"$unset,omitempty"`
+}
+
+// Check reports whether an update can be applied. A property may only be used
+// by one operation of an update, so that the order they apply in does not matter.
+func (u *Update) Check() error {
+	seen := make(map[string]bool)
+	use := func(name string) error {
+		if name == "" {
+			return ErrInvalidName
+		}
+		if seen[name] {
+			return fmt.Errorf("%w: %s", ErrConflict, name)
+		}
+
+		seen[name] = true
+		return nil
+	}
+
+	for name := range u.Set {
+		if err := use(name); err != nil {
+			return err
+		}
+	}
+	for name := range u.SetOnce {
+		if err := use(name); err != nil {
+			return err
+		}
+	}
+	for name, delta := range u.Increment {
+		if err := use(name); err != nil {
+			return err
+		}
+		if _, err := delta.Float64(); err != nil {
+			return fmt.Errorf("%w: %s", ErrNotNumber, name)
+		}
+	}
+	for name := range u.Append {
+		if err := use(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range u.Unset {
+		if err := use(name); err != nil {
+			return err
+		}
+	}
+
+	if len(seen) == 0 {
+		return ErrEmptyUpdate
+	}
+
+	return nil
+}
+
+// Decode parses stored properties, keeping numbers as json.Number so that
+// integers stay exact.
+func Decode(s string) (map[string]interface{}, error) {
+	props := make(map[string]interface{})
+	if s == "" {
+		return props, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader([]byte(s)))
+	d.UseNumber()
+	if err := d.Decode(&props); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidValue, err.Error())
+	}
+
+	if props == nil {
+		props = make(map[string]interface{})
+	}
+	return props, nil
+}
+
+// Encode returns properties in their stored form.
+func Encode(props map[string]interface{}) (string, error) {
+	b, err := json.Marshal(props)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidValue, err.Error())
+	}
+
+	if len(b) > maxSize {
+		return "", ErrTooLarge
+	}
+	return string(b), nil
+}
+
+// Apply merges u into props. It returns the new values of the properties that
+// changed and the names of those that were removed. props is left as is when
+// an error is returned.
+func Apply(props map[string]interface{}, u *Update) (changed map[string]interface{}, unset []string, err error) {
+	next := make(map[string]interface{}, len(props))
+	for name, v := range props {
+		next[name] = v
+	}
+
+	changed = make(map[string]interface{})
+	for name, v := range u.SetOnce {
+		if _, ok := next[name]; !ok {
+			next[name] = v
+			changed[name] = v
+		}
+	}
+
+	for name, v := range u.Set {
+		if old, ok := next[name]; !ok || !reflect.DeepEqual(old, v) {
+			next[name] = v
+			changed[name] = v
+		}
+	}
+
+	for name, delta := range u.Increment {
+		sum, err := add(next[name], delta)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", err, name)
+		}
+
+		if sum != next[name] {
+			next[name] = sum
+			changed[name] = sum
+		}
+	}
+
+	for name, v := range u.Append {
+		var list []interface{}
+		switch old := next[name].(type) {
+		case nil:
+		case []interface{}:
+			list = old
+		default:
+			return nil, nil, fmt.Errorf("%w: %s", ErrNotList, name)
+		}
+
+		// a list value appends each of its items
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		list = append(append([]interface{}{}, list...), items...)
+		next[name] = list
+		changed[name] = list
+	}
+
+	for _, name := range u.Unset {
+		if _, ok := next[name]; ok {
+			delete(next, name)
+			unset = append(unset, name)
+		}
+	}
+
+	for name := range props {
+		delete(props, name)
+	}
+	for name, v := range next {
+		props[name] = v
+	}
+
+	return changed, unset, nil
+}
+
+// add sums a property and a delta, staying an integer when both are.
+func add(v interface{}, delta json.Number) (json.Number, error) {
+	var old json.Number
+	switch n := v.(type) {
+	case nil:
+		old = "0"
+	case json.Number:
+		old = n
+	case float64:
+		old = json.Number(strconv.FormatFloat(n, 'g', -1, 64))
+	default:
+		return "", ErrNotNumber
+	}
+
+	if a, err := old.Int64(); err == nil {
+		if b, err := delta.Int64(); err == nil {
+			if b == 0 {
+				return old, nil
+			}
+			return json.Number(strconv.FormatInt(a+b, 10)), nil
+		}
+	}
+
+	a, err := old.Float64()
+	if err != nil {
+		return "", ErrNotNumber
+	}
+	b, err := delta.Float64()
+	if err != nil {
+		return "", ErrNotNumber
+	}
+
+	if b == 0 {
+		return old, nil
+	}
+	return json.Number(strconv.FormatFloat(a+b, 'g', -1, 64)), nil
+}
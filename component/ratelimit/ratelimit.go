@@ -0,0 +1,174 @@
+//
+// File: ratelimit.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package ratelimit keeps token buckets and quota counters in redis, so that
+// every proxy instance shares the same limits.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills the bucket in KEYS[1] from the redis clock and takes
+// ARGV[3] tokens from it. A cost above the burst is let through on a full
+// bucket and leaves it in debt, so that large batches are slowed down instead
+// of never passing. It returns whether the tokens were taken and otherwise how
+// many milliseconds to wait for them.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local need = math.min(cost, burst)
+if tokens < need then
+	return {0, math.ceil((need - tokens) * 1000 / rate)}
+end
+
+tokens = tokens - cost
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {1, 0}
+`)
+
+// giveScript puts ARGV[2] tokens back into the bucket in KEYS[1], up to the
+// burst in ARGV[1]. A bucket that has expired is full already.
+var giveScript = redis.NewScript(`
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens == nil then
+	return 0
+end
+
+tokens = math.min(tonumber(ARGV[1]), tokens + tonumber(ARGV[2]))
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens))
+return 0
+`)
+
+// countScript adds ARGV[3] to the daily counter in KEYS[1] and the monthly
+// counter in KEYS[2] unless that goes over the quota in ARGV[1] or ARGV[2].
+// A quota of zero is no quota. It returns 0 when counted, 1 when the daily and
+// 2 when the monthly quota would be exceeded.
+var countScript = redis.NewScript(`
+local cost = tonumber(ARGV[3])
+for i = 1, 2 do
+	local quota = tonumber(ARGV[i])
+	if quota > 0 then
+		local used = tonumber(redis.call('GET', KEYS[i]) or '0')
+		if used + cost > quota then
+			return i
+		end
+	end
+end
+
+for i = 1, 2 do
+	if redis.call('INCRBY', KEYS[i], cost) == cost then
+		redis.call('EXPIRE', KEYS[i], ARGV[3 + i])
+	end
+end
+return 0
+`)
+
+// refundScript takes ARGV[1] back from the counters in KEYS that still exist.
+var refundScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('DECRBY', KEYS[i], ARGV[1])
+	end
+end
+return 0
+`)
+
+const (
+	QuotaOK = iota
+	QuotaDaily
+	QuotaMonthly
+)
+
+// Bucket is a token bucket refilled at Rate tokens per second up to Burst.
+type Bucket struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the bucket limits anything.
+func (b Bucket) Enabled() bool {
+	return b.Rate > 0
+}
+
+func (b Bucket) burst() int {
+	if b.Burst > 0 {
+		return b.Burst
+	}
+
+	// a bucket without a burst holds one second of tokens
+	return int(math.Max(1, math.Ceil(b.Rate)))
+}
+
+// Take takes cost tokens from the bucket kept under key. When there are not
+// enough tokens it returns false and how long until there are.
+func Take(ctx context.Context, rdb redis.Scripter, key string, b Bucket, cost int) (bool, time.Duration, error) {
+	if !b.Enabled() || cost <= 0 {
+		return true, 0, nil
+	}
+
+	res, err := takeScript.Run(ctx, rdb, []string{key}, b.Rate, b.burst(), cost).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// Give puts back cost tokens taken by Take, for events that were then not let
+// through.
+func Give(ctx context.Context, rdb redis.Scripter, key string, b Bucket, cost int) error {
+	if !b.Enabled() || cost <= 0 {
+		return nil
+	}
+
+	return giveScript.Run(ctx, rdb, []string{key}, b.burst(), cost).Err()
+}
+
+// Count counts cost events against the daily and monthly quotas kept under
+// dayKey and monthKey, which must be unique to the current day and month. The
+// counters expire with dayTTL and monthTTL. Nothing is counted when a quota
+// would be exceeded.
+func Count(ctx context.Context, rdb redis.Scripter, dayKey, monthKey string, daily, monthly int64, dayTTL, monthTTL time.Duration, cost int) (int, error) {
+	if (daily <= 0 && monthly <= 0) || cost <= 0 {
+		return QuotaOK, nil
+	}
+
+	return countScript.Run(ctx, rdb, []string{dayKey, monthKey}, daily, monthly, cost, int64(dayTTL/time.Second)+1, int64(monthTTL/time.Second)+1).Int()
+}
+
+// Refund gives back cost events counted by Count, for events that were then
+// not let through.
+func Refund(ctx context.Context, rdb redis.Scripter, dayKey, monthKey string, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+
+	return refundScript.Run(ctx, rdb, []string{dayKey, monthKey}, cost).Err()
+}
@@ -0,0 +1,93 @@
+//
+// File: detector.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package redaction
+
+import (
+	"oset/model"
+	"regexp"
+)
+
+type detector struct {
+	pattern *regexp.Regexp
+	// valid filters out matches of the pattern that are not the data looked for
+	valid func(match string) bool
+}
+
+var detectors = map[string]detector{
+	model.REDACT_DETECTOR_EMAIL: {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	model.REDACT_DETECTOR_PHONE: {
+		pattern: regexp.MustCompile(`(?:\+|\b)(?:\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){2,4}\b`),
+		valid: func(match string) bool {
+			n := digits(match)
+			if n == len(match) && n > 12 {
+				// a bare run of digits this long is more likely a timestamp or an id
+				return false
+			}
+			return n >= 7 && n <= 15
+		},
+	},
+	model.REDACT_DETECTOR_CARD: {
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   luhn,
+	},
+	model.REDACT_DETECTOR_TOKEN: {
+		// json web tokens and bearer credentials
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+|(?i:\bbearer\s+)[A-Za-z0-9._~+/-]+=*`),
+	},
+}
+
+func digits(s string) (n int) {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			n++
+		}
+	}
+
+	return
+}
+
+// luhn reports whether the digits of s pass the checksum of card numbers.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// replace calls repl for every valid match of d in s.
+func (d detector) replace(s string, repl func(string) string) (string, bool) {
+	found := false
+	out := d.pattern.ReplaceAllStringFunc(s, func(match string) string {
+		if d.valid != nil && !d.valid(match) {
+			return match
+		}
+
+		found = true
+		return repl(match)
+	})
+
+	return out, found
+}
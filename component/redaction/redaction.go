@@ -0,0 +1,393 @@
+//
+// File: redaction.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package redaction removes personal data from event properties under the
+// redaction rules of their app.
+package redaction
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+
+	// what a dropped match is replaced by in text that is not json
+	droppedText = "[redacted]"
+)
+
+var (
+	ErrInvalidAction   = errors.New("invalid action, must be drop, hash or mask")
+	ErrInvalidDetector = errors.New("invalid detector, must be email, phone, card or token")
+	ErrNoSelector      = errors.New("a rule needs a path or a detector")
+)
+
+type rule struct {
+	model.RedactionRule
+	path     []string
+	detector *detector
+}
+
+type appRules struct {
+	rules    []*rule
+	salt     string
+	loadedAt time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[int]*appRules)
+)
+
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, ".")
+}
+
+func compile(r model.RedactionRule) (*rule, error) {
+	switch r.Action {
+	case model.REDACT_ACTION_DROP, model.REDACT_ACTION_HASH, model.REDACT_ACTION_MASK:
+	default:
+		return nil, ErrInvalidAction
+	}
+
+	compiled := &rule{
+		RedactionRule: r,
+		path:          splitPath(r.Path),
+	}
+
+	if r.Detector != "" {
+		d, ok := detectors[r.Detector]
+		if !ok {
+			return nil, ErrInvalidDetector
+		}
+		compiled.detector = &d
+	}
+
+	if compiled.detector == nil && len(compiled.path) == 0 {
+		return nil, ErrNoSelector
+	}
+
+	for _, seg := range compiled.path {
+		if seg == "" {
+			return nil, fmt.Errorf("invalid path %q", r.Path)
+		}
+	}
+
+	return compiled, nil
+}
+
+// Check reports whether a rule can be applied.
+func Check(r *model.RedactionRule) error {
+	_, err := compile(*r)
+	return err
+}
+
+// NewSalt returns a random salt for the hashes of an app.
+func NewSalt() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Apply redacts the json object data under the rules of an app. Data that
+// is not json is returned unchanged, it is rejected before it goes anywhere.
+func Apply(aid int, data string) (string, error) {
+	rules, err := load(aid)
+	if err != nil {
+		return data, err
+	}
+
+	if len(rules.rules) == 0 {
+		return data, nil
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&v); err != nil {
+		return data, nil
+	}
+
+	for _, r := range rules.rules {
+		v = r.apply(v, rules.salt)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(v); err != nil {
+		return data, err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Text applies the detector rules of an app to text that can not be parsed,
+// such as a rejected body that is about to be logged. Without the rules of the
+// app the text is withheld altogether.
+func Text(aid int, s string) string {
+	rules, err := load(aid)
+	if err != nil {
+		return droppedText
+	}
+
+	for _, r := range rules.rules {
+		if r.detector == nil {
+			continue
+		}
+
+		s, _ = r.detector.replace(s, func(match string) string {
+			if r.Action == model.REDACT_ACTION_DROP {
+				return droppedText
+			}
+			return r.redact(match, rules.salt)
+		})
+	}
+
+	return s
+}
+
+// Invalidate drops the cached rules of an app so that the next Apply reloads
+// them. Other proxy instances pick up changes once their cache expires.
+func Invalidate(aid int) {
+	cacheMu.Lock()
+	delete(cache, aid)
+	cacheMu.Unlock()
+}
+
+func (r *rule) redact(s string, salt string) string {
+	if r.Action == model.REDACT_ACTION_HASH {
+		mac := hmac.New(sha256.New, []byte(salt))
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return mask(s)
+}
+
+// mask keeps the last four characters of longer values, so that they can
+// still be told apart.
+func mask(s string) string {
+	runes := []rune(s)
+	keep := 0
+	if len(runes) > 8 {
+		keep = 4
+	}
+
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// apply redacts whatever the rule selects in v.
+func (r *rule) apply(v interface{}, salt string) interface{} {
+	act := func(x interface{}) (interface{}, bool) {
+		if r.Action == model.REDACT_ACTION_DROP {
+			return nil, true
+		}
+
+		switch t := x.(type) {
+		case string:
+			return r.redact(t, salt), false
+		case json.Number:
+			return r.redact(t.String(), salt), false
+		case bool:
+			return r.redact(strconv.FormatBool(t), salt), false
+		case nil:
+			return nil, false
+		}
+
+		// objects and arrays are replaced as a whole
+		jx, _ := json.Marshal(x)
+		if r.Action == model.REDACT_ACTION_HASH {
+			return r.redact(string(jx), salt), false
+		}
+		return mask(string(jx)), false
+	}
+
+	if r.detector != nil {
+		act = func(x interface{}) (interface{}, bool) {
+			return scan(x, func(s string) (interface{}, bool) {
+				out, found := r.detector.replace(s, func(match string) string {
+					return r.redact(match, salt)
+				})
+
+				if found && r.Action == model.REDACT_ACTION_DROP {
+					return nil, true
+				}
+				return out, false
+			})
+		}
+	}
+
+	v, drop := walk(v, r.path, act)
+	if drop {
+		return map[string]interface{}{}
+	}
+
+	return v
+}
+
+// walk replaces every value selected by path below v by what fn returns for
+// it, removing it when fn asks to drop it. Dropped array elements become null
+// so that the other elements keep their index.
+func walk(v interface{}, path []string, fn func(interface{}) (interface{}, bool)) (interface{}, bool) {
+	if len(path) == 0 {
+		return fn(v)
+	}
+
+	seg, rest := path[0], path[1:]
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if seg != "*" && seg != k {
+				continue
+			}
+
+			if nv, drop := walk(child, rest, fn); drop {
+				delete(t, k)
+			} else {
+				t[k] = nv
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			if seg != "*" && seg != strconv.Itoa(i) {
+				continue
+			}
+
+			if nv, drop := walk(child, rest, fn); drop {
+				t[i] = nil
+			} else {
+				t[i] = nv
+			}
+		}
+	}
+
+	return v, false
+}
+
+// scan calls fn on every string below v.
+func scan(v interface{}, fn func(string) (interface{}, bool)) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		return fn(t)
+	case map[string]interface{}:
+		for k, child := range t {
+			if nv, drop := scan(child, fn); drop {
+				delete(t, k)
+			} else {
+				t[k] = nv
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			if nv, drop := scan(child, fn); drop {
+				t[i] = nil
+			} else {
+				t[i] = nv
+			}
+		}
+	}
+
+	return v, false
+}
+
+func load(aid int) (*appRules, error) {
+	ttl := viper.GetDuration("redaction.cache_ttl")
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	cacheMu.RLock()
+	cached, ok := cache[aid]
+	cacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < ttl {
+		return cached, nil
+	}
+
+	var rules []model.RedactionRule
+	res := db.Mysql().Where("aid = ?", aid).Order("id").Find(&rules)
+	if res.Error != nil {
+		// stale rules are better than none while mysql is unavailable
+		if ok {
+			return cached, nil
+		}
+		return nil, res.Error
+	}
+
+	loaded := &appRules{
+		rules:    make([]*rule, 0, len(rules)),
+		loadedAt: time.Now(),
+	}
+	for _, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %d: %w", r.ID, err)
+		}
+		loaded.rules = append(loaded.rules, compiled)
+	}
+
+	if len(loaded.rules) > 0 {
+		salt, err := appSalt(aid)
+		if err != nil {
+			if ok {
+				return cached, nil
+			}
+			return nil, err
+		}
+		loaded.salt = salt
+	}
+
+	cacheMu.Lock()
+	cache[aid] = loaded
+	cacheMu.Unlock()
+
+	return loaded, nil
+}
+
+// appSalt returns the salt of an app, giving apps created without one a salt
+// that every proxy instance agrees on.
+func appSalt(aid int) (string, error) {
+	var app model.App
+	res := db.Mysql().Select("aid", "redaction_salt").Where("aid = ?", aid).First(&app)
+	if res.Error != nil {
+		return "", res.Error
+	}
+
+	if app.RedactionSalt != "" {
+		return app.RedactionSalt, nil
+	}
+
+	res = db.Mysql().Model(&model.App{}).Where("aid = ? AND (redaction_salt = '' OR redaction_salt IS NULL)", aid).Update("redaction_salt", NewSalt())
+	if res.Error != nil {
+		return "", res.Error
+	}
+
+	res = db.Mysql().Select("aid", "redaction_salt").Where("aid = ?", aid).First(&app)
+	return app.RedactionSalt, res.Error
+}
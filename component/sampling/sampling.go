@@ -0,0 +1,143 @@
+//
+// File: sampling.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package sampling decides which reported events are kept under the sampling
+// rules of their app.
+package sampling
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"oset/db"
+	"oset/model"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+)
+
+var (
+	ErrInvalidPattern = errors.New("invalid sampling pattern")
+	ErrInvalidRate    = errors.New("sampling rate must be within [0, 1]")
+)
+
+type appRules struct {
+	rules    []model.SamplingRule
+	loadedAt time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[int]*appRules)
+)
+
+// Check reports whether a rule can be applied.
+func Check(rule *model.SamplingRule) error {
+	if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+		return ErrInvalidPattern
+	}
+
+	if rule.Rate < 0 || rule.Rate > 1 {
+		return ErrInvalidRate
+	}
+
+	return nil
+}
+
+// Sample decides whether an event is kept. Rate is the rate of the rule that
+// applied, or 1 when the event is not sampled at all.
+func Sample(aid int, event string, did string) (keep bool, rate float64, err error) {
+	rules, err := load(aid)
+	if err != nil {
+		return true, 1, err
+	}
+
+	for i := range rules {
+		if ok, _ := path.Match(rules[i].Pattern, event); !ok {
+			continue
+		}
+
+		rate = rules[i].Rate
+		if rate >= 1 {
+			return true, 1, nil
+		}
+
+		var x float64
+		if rules[i].ByDevice {
+			x = deviceFraction(aid, did)
+		} else {
+			x = rand.Float64()
+		}
+
+		return x < rate, rate, nil
+	}
+
+	return true, 1, nil
+}
+
+// deviceFraction maps a device to a fixed point in [0, 1). It does not depend
+// on the rule, so the devices kept at a lower rate are also kept at any higher
+// one and a device is kept for all events or none under equal rates.
+func deviceFraction(aid int, did string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.Itoa(aid) + ":" + did))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// Invalidate drops the cached rules of an app so that the next Sample reloads
+// them. Other proxy instances pick up changes once their cache expires.
+func Invalidate(aid int) {
+	cacheMu.Lock()
+	delete(cache, aid)
+	cacheMu.Unlock()
+}
+
+func load(aid int) ([]model.SamplingRule, error) {
+	ttl := viper.GetDuration("sampling.cache_ttl")
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	cacheMu.RLock()
+	cached, ok := cache[aid]
+	cacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < ttl {
+		return cached.rules, nil
+	}
+
+	var rules []model.SamplingRule
+	res := db.Mysql().Where("aid = ?", aid).Find(&rules)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+
+	cacheMu.Lock()
+	cache[aid] = &appRules{
+		rules:    rules,
+		loadedAt: time.Now(),
+	}
+	cacheMu.Unlock()
+
+	return rules, nil
+}
@@ -0,0 +1,165 @@
+//
+// File: registry.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"oset/db"
+	"oset/model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+)
+
+const (
+	TagDeprecated = "deprecated_event"
+	TagViolation  = "schema_violation"
+)
+
+var (
+	ErrViolation = errors.New("event does not match its schema")
+)
+
+type definition struct {
+	model.EventSchema
+	compiled *Schema
+}
+
+type appDefinitions struct {
+	defs     map[string]*definition
+	loadedAt time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[int]*appDefinitions)
+)
+
+// Result is the outcome of checking an event against the registry of its app.
+type Result struct {
+	// Enforced is false when the app has no registered events at all, in
+	// which case nothing is validated.
+	Enforced   bool
+	Registered bool
+	Deprecated bool
+	Version    int
+	Violations []string
+}
+
+// Check validates an event's data against the definition registered for it.
+func Check(aid int, event string, data map[string]interface{}) (res Result, err error) {
+	defs, err := load(aid)
+	if err != nil {
+		return
+	}
+
+	if len(defs) == 0 {
+		return
+	}
+	res.Enforced = true
+
+	def, ok := defs[event]
+	if !ok {
+		res.Violations = append(res.Violations, fmt.Sprintf("event %q is not registered", event))
+		return
+	}
+
+	res.Registered = true
+	res.Version = def.Version
+	res.Deprecated = def.Status == model.SCHEMA_STATUS_DEPRECATED
+
+	for _, name := range def.Required {
+		if _, ok := data[name]; !ok {
+			res.Violations = append(res.Violations, fmt.Sprintf("$: missing required property %q", name))
+		}
+	}
+
+	res.Violations = append(res.Violations, def.compiled.Validate(data)...)
+	return
+}
+
+// Apply returns what a schema mode makes of a result: the tags to put on the
+// event, and ErrViolation when the mode rejects it. Violations are let through
+// untagged in warn mode.
+func (r *Result) Apply(mode string) (tags []string, err error) {
+	if r.Deprecated {
+		tags = append(tags, TagDeprecated)
+	}
+
+	if len(r.Violations) == 0 {
+		return
+	}
+
+	switch mode {
+	case model.SCHEMA_MODE_REJECT:
+		err = fmt.Errorf("%w: %s", ErrViolation, strings.Join(r.Violations, "; "))
+	case model.SCHEMA_MODE_TAG:
+		tags = append(tags, TagViolation)
+	}
+
+	return
+}
+
+// Invalidate drops the cached definitions of an app so that the next Check
+// reloads them. Other proxy instances pick up changes once their cache expires.
+func Invalidate(aid int) {
+	cacheMu.Lock()
+	delete(cache, aid)
+	cacheMu.Unlock()
+}
+
+func load(aid int) (map[string]*definition, error) {
+	ttl := viper.GetDuration("schema.cache_ttl")
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	cacheMu.RLock()
+	cached, ok := cache[aid]
+	cacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < ttl {
+		return cached.defs, nil
+	}
+
+	var schemas []model.EventSchema
+	res := db.Mysql().Where("aid = ?", aid).Find(&schemas)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	defs := make(map[string]*definition, len(schemas))
+	for _, s := range schemas {
+		compiled, err := Compile(s.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("event %q: %w", s.Event, err)
+		}
+
+		defs[s.Event] = &definition{
+			EventSchema: s,
+			compiled:    compiled,
+		}
+	}
+
+	cacheMu.Lock()
+	cache[aid] = &appDefinitions{
+		defs:     defs,
+		loadedAt: time.Now(),
+	}
+	cacheMu.Unlock()
+
+	return defs, nil
+}
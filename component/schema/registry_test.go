@@ -0,0 +1,51 @@
+//
+// File: registry_test.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package schema
+
+import (
+	"errors"
+	"oset/model"
+	"reflect"
+	"testing"
+)
+
+func TestResultApply(t *testing.T) {
+	violations := []string{"$: missing required property \"id\""}
+
+	tests := []struct {
+		name   string
+		result Result
+		mode   string
+		tags   []string
+		reject bool
+	}{
+		{name: "valid reject", result: Result{Enforced: true, Registered: true}, mode: model.SCHEMA_MODE_REJECT},
+		{name: "valid tag", result: Result{Enforced: true, Registered: true}, mode: model.SCHEMA_MODE_TAG},
+		{name: "deprecated", result: Result{Enforced: true, Registered: true, Deprecated: true}, mode: model.SCHEMA_MODE_WARN, tags: []string{TagDeprecated}},
+		{name: "violation reject", result: Result{Enforced: true, Registered: true, Violations: violations}, mode: model.SCHEMA_MODE_REJECT, reject: true},
+		{name: "violation warn", result: Result{Enforced: true, Registered: true, Violations: violations}, mode: model.SCHEMA_MODE_WARN},
+		{name: "violation tag", result: Result{Enforced: true, Registered: true, Violations: violations}, mode: model.SCHEMA_MODE_TAG, tags: []string{TagViolation}},
+		{name: "deprecated violation tag", result: Result{Enforced: true, Registered: true, Deprecated: true, Violations: violations}, mode: model.SCHEMA_MODE_TAG, tags: []string{TagDeprecated, TagViolation}},
+		{name: "unregistered reject", result: Result{Enforced: true, Violations: []string{`event "x" is not registered`}}, mode: model.SCHEMA_MODE_REJECT, reject: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := tt.result.Apply(tt.mode)
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("Apply() tags = %q, want %q", tags, tt.tags)
+			}
+
+			if tt.reject != errors.Is(err, ErrViolation) || (!tt.reject && err != nil) {
+				t.Errorf("Apply() error = %v, want rejected %v", err, tt.reject)
+			}
+		})
+	}
+}
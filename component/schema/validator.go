@@ -0,0 +1,429 @@
+//
+// File: validator.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSchema = errors.New("invalid json schema")
+)
+
+// Schema is a compiled json schema. Only the subset of draft 7 that is useful
+// for event properties is supported: type, enum, const, properties, required,
+// additionalProperties, items, min/max (length, items, value), pattern and the
+// allOf/anyOf/oneOf/not combinators.
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *Schema
+	NoAdditional         bool
+	Items                *Schema
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinLength            *int
+	MaxLength            *int
+	MinItems             *int
+	MaxItems             *int
+	Pattern              *regexp.Regexp
+	AllOf                []*Schema
+	AnyOf                []*Schema
+	OneOf                []*Schema
+	Not                  *Schema
+}
+
+// Compile parses a json schema document. An empty document accepts anything.
+func Compile(doc string) (*Schema, error) {
+	if strings.TrimSpace(doc) == "" {
+		return &Schema{}, nil
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(doc), &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchema, err.Error())
+	}
+
+	return compile(raw, "#")
+}
+
+func compile(raw interface{}, path string) (*Schema, error) {
+	if b, ok := raw.(bool); ok {
+		if b {
+			return &Schema{}, nil
+		}
+		return &Schema{Not: &Schema{}}, nil
+	}
+
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be an object or a boolean", ErrInvalidSchema, path)
+	}
+
+	s := &Schema{}
+	var err error
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.Types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s/type must contain strings", ErrInvalidSchema, path)
+			}
+			s.Types = append(s.Types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s/type must be a string or an array", ErrInvalidSchema, path)
+	}
+
+	if enum, ok := m["enum"]; ok {
+		values, ok := enum.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/enum must be an array", ErrInvalidSchema, path)
+		}
+		s.Enum = values
+	}
+
+	if c, ok := m["const"]; ok {
+		s.Const = c
+		s.HasConst = true
+	}
+
+	if props, ok := m["properties"]; ok {
+		pm, ok := props.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/properties must be an object", ErrInvalidSchema, path)
+		}
+
+		s.Properties = make(map[string]*Schema, len(pm))
+		for name, sub := range pm {
+			if s.Properties[name], err = compile(sub, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if req, ok := m["required"]; ok {
+		items, ok := req.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/required must be an array", ErrInvalidSchema, path)
+		}
+
+		for _, item := range items {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s/required must contain strings", ErrInvalidSchema, path)
+			}
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	if ap, ok := m["additionalProperties"]; ok {
+		if b, isBool := ap.(bool); isBool {
+			s.NoAdditional = !b
+		} else if s.AdditionalProperties, err = compile(ap, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	if items, ok := m["items"]; ok {
+		if s.Items, err = compile(items, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, dst := range map[string]**float64{
+		"minimum":          &s.Minimum,
+		"maximum":          &s.Maximum,
+		"exclusiveMinimum": &s.ExclusiveMinimum,
+		"exclusiveMaximum": &s.ExclusiveMaximum,
+	} {
+		if v, ok := m[key]; ok {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s/%s must be a number", ErrInvalidSchema, path, key)
+			}
+			*dst = &f
+		}
+	}
+
+	for key, dst := range map[string]**int{
+		"minLength": &s.MinLength,
+		"maxLength": &s.MaxLength,
+		"minItems":  &s.MinItems,
+		"maxItems":  &s.MaxItems,
+	} {
+		if v, ok := m[key]; ok {
+			f, ok := v.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%w: %s/%s must be a non-negative integer", ErrInvalidSchema, path, key)
+			}
+			n := int(f)
+			*dst = &n
+		}
+	}
+
+	if p, ok := m["pattern"]; ok {
+		ps, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/pattern must be a string", ErrInvalidSchema, path)
+		}
+		if s.Pattern, err = regexp.Compile(ps); err != nil {
+			return nil, fmt.Errorf("%w: %s/pattern: %s", ErrInvalidSchema, path, err.Error())
+		}
+	}
+
+	for key, dst := range map[string]*[]*Schema{
+		"allOf": &s.AllOf,
+		"anyOf": &s.AnyOf,
+		"oneOf": &s.OneOf,
+	} {
+		if v, ok := m[key]; ok {
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%w: %s/%s must be a non-empty array", ErrInvalidSchema, path, key)
+			}
+
+			for i, sub := range list {
+				cs, err := compile(sub, fmt.Sprintf("%s/%s/%d", path, key, i))
+				if err != nil {
+					return nil, err
+				}
+				*dst = append(*dst, cs)
+			}
+		}
+	}
+
+	if not, ok := m["not"]; ok {
+		if s.Not, err = compile(not, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Validate checks value (as produced by encoding/json) against the schema and
+// returns every violation found, each prefixed with its location.
+func (s *Schema) Validate(value interface{}) []string {
+	return s.validate(value, "$", nil)
+}
+
+func (s *Schema) validate(value interface{}, path string, errs []string) []string {
+	if len(s.Types) > 0 {
+		matched := false
+		for _, t := range s.Types {
+			if matchType(t, value) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.Types, " or "), typeOf(value)))
+		}
+	}
+
+	if s.Enum != nil {
+		found := false
+		for _, e := range s.Enum {
+			if equal(e, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value is not one of the allowed values", path))
+		}
+	}
+
+	if s.HasConst && !equal(s.Const, value) {
+		errs = append(errs, fmt.Sprintf("%s: value does not match const", path))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			sub, ok := s.Properties[k]
+			if ok {
+				errs = sub.validate(v[k], path+"."+k, errs)
+			} else if s.NoAdditional {
+				errs = append(errs, fmt.Sprintf("%s: additional property %q is not allowed", path, k))
+			} else if s.AdditionalProperties != nil {
+				errs = s.AdditionalProperties.validate(v[k], path+"."+k, errs)
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %d items", path, *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at most %d items", path, *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: expected length >= %d", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: expected length <= %d", path, *s.MaxLength))
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s: does not match pattern %q", path, s.Pattern.String()))
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fmt.Sprintf("%s: expected >= %v", path, *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fmt.Sprintf("%s: expected <= %v", path, *s.Maximum))
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			errs = append(errs, fmt.Sprintf("%s: expected > %v", path, *s.ExclusiveMinimum))
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			errs = append(errs, fmt.Sprintf("%s: expected < %v", path, *s.ExclusiveMaximum))
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		errs = sub.validate(value, path, errs)
+	}
+
+	if len(s.AnyOf) > 0 {
+		ok := false
+		for _, sub := range s.AnyOf {
+			if len(sub.validate(value, path, nil)) == 0 {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: does not match any of anyOf", path))
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if len(sub.validate(value, path, nil)) == 0 {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			errs = append(errs, fmt.Sprintf("%s: matches %d of oneOf, expected exactly 1", path, matched))
+		}
+	}
+
+	if s.Not != nil && len(s.Not.validate(value, path, nil)) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: must not match schema in not", path))
+	}
+
+	return errs
+}
+
+func matchType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+func equal(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,166 @@
+//
+// File: validator_test.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		invalid bool
+	}{
+		{name: "empty", doc: ""},
+		{name: "true", doc: `true`},
+		{name: "false", doc: `false`},
+		{name: "full", doc: `{
+			"type": "object",
+			"properties": {
+				"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+				"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 3},
+				"price": {"type": ["number", "null"], "minimum": 0, "exclusiveMaximum": 100}
+			},
+			"required": ["name"],
+			"additionalProperties": false,
+			"anyOf": [{"required": ["tags"]}, {"required": ["price"]}],
+			"not": {"const": {}}
+		}`},
+		{name: "not json", doc: `{"type":`, invalid: true},
+		{name: "not an object", doc: `1`, invalid: true},
+		{name: "type not a string", doc: `{"type": 1}`, invalid: true},
+		{name: "type list not strings", doc: `{"type": ["string", 1]}`, invalid: true},
+		{name: "enum not an array", doc: `{"enum": "a"}`, invalid: true},
+		{name: "properties not an object", doc: `{"properties": []}`, invalid: true},
+		{name: "invalid property", doc: `{"properties": {"a": 1}}`, invalid: true},
+		{name: "required not an array", doc: `{"required": "a"}`, invalid: true},
+		{name: "required not strings", doc: `{"required": [1]}`, invalid: true},
+		{name: "minimum not a number", doc: `{"minimum": "1"}`, invalid: true},
+		{name: "negative minLength", doc: `{"minLength": -1}`, invalid: true},
+		{name: "fractional maxItems", doc: `{"maxItems": 1.5}`, invalid: true},
+		{name: "pattern not a string", doc: `{"pattern": 1}`, invalid: true},
+		{name: "invalid pattern", doc: `{"pattern": "("}`, invalid: true},
+		{name: "empty anyOf", doc: `{"anyOf": []}`, invalid: true},
+		{name: "invalid oneOf", doc: `{"oneOf": [1]}`, invalid: true},
+		{name: "invalid not", doc: `{"not": "a"}`, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile(tt.doc)
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidSchema) {
+					t.Fatalf("Compile() error = %v, want ErrInvalidSchema", err)
+				}
+				return
+			}
+
+			if err != nil || s == nil {
+				t.Fatalf("Compile() = %v, %v", s, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string
+	}{
+		{name: "empty schema", schema: ``, value: `{"a": 1}`},
+		{name: "false schema", schema: `false`, value: `1`, want: []string{"$: must not match schema in not"}},
+
+		{name: "type", schema: `{"type": "string"}`, value: `"a"`},
+		{name: "type mismatch", schema: `{"type": "string"}`, value: `1`, want: []string{"$: expected string, got integer"}},
+		{name: "type list", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "type list mismatch", schema: `{"type": ["string", "null"]}`, value: `true`, want: []string{"$: expected string or null, got boolean"}},
+		{name: "integer", schema: `{"type": "integer"}`, value: `3`},
+		{name: "integer fraction", schema: `{"type": "integer"}`, value: `3.5`, want: []string{"$: expected integer, got number"}},
+		{name: "number takes integers", schema: `{"type": "number"}`, value: `3`},
+		{name: "array", schema: `{"type": "array"}`, value: `{}`, want: []string{"$: expected array, got object"}},
+
+		{name: "required", schema: `{"required": ["a", "b"]}`, value: `{"a": 1, "b": 2}`},
+		{name: "required missing", schema: `{"required": ["a", "b"]}`, value: `{"b": 2}`, want: []string{`$: missing required property "a"`}},
+		{name: "required on non objects", schema: `{"required": ["a"]}`, value: `1`},
+
+		{name: "properties", schema: `{"properties": {"a": {"type": "string"}}}`, value: `{"a": 1, "b": 2}`, want: []string{"$.a: expected string, got integer"}},
+		{name: "nested properties", schema: `{"properties": {"a": {"properties": {"b": {"type": "boolean"}}}}}`, value: `{"a": {"b": "x"}}`, want: []string{"$.a.b: expected boolean, got string"}},
+		{name: "no additional properties", schema: `{"properties": {"a": {}}, "additionalProperties": false}`, value: `{"a": 1, "c": 2, "b": 3}`, want: []string{
+			`$: additional property "b" is not allowed`,
+			`$: additional property "c" is not allowed`,
+		}},
+		{name: "additional properties schema", schema: `{"properties": {"a": {}}, "additionalProperties": {"type": "number"}}`, value: `{"a": "x", "b": "y"}`, want: []string{"$.b: expected number, got string"}},
+
+		{name: "enum", schema: `{"enum": ["a", 1, null]}`, value: `1`},
+		{name: "enum mismatch", schema: `{"enum": ["a", 1, null]}`, value: `"b"`, want: []string{"$: value is not one of the allowed values"}},
+		{name: "enum object", schema: `{"enum": [{"a": [1, 2]}]}`, value: `{"a": [1, 2]}`},
+		{name: "const", schema: `{"const": "a"}`, value: `"a"`},
+		{name: "const mismatch", schema: `{"const": "a"}`, value: `"b"`, want: []string{"$: value does not match const"}},
+		{name: "const null", schema: `{"const": null}`, value: `0`, want: []string{"$: value does not match const"}},
+
+		{name: "pattern", schema: `{"pattern": "^[a-z]+$"}`, value: `"abc"`},
+		{name: "pattern mismatch", schema: `{"pattern": "^[a-z]+$"}`, value: `"aBc"`, want: []string{`$: does not match pattern "^[a-z]+$"`}},
+		{name: "pattern on non strings", schema: `{"pattern": "^a$"}`, value: `1`},
+
+		{name: "minLength counts runes", schema: `{"minLength": 2, "maxLength": 2}`, value: `"日本"`},
+		{name: "minLength", schema: `{"minLength": 2}`, value: `"a"`, want: []string{"$: expected length >= 2"}},
+		{name: "maxLength", schema: `{"maxLength": 2}`, value: `"abc"`, want: []string{"$: expected length <= 2"}},
+		{name: "minimum", schema: `{"minimum": 1, "maximum": 3}`, value: `1`},
+		{name: "below minimum", schema: `{"minimum": 1}`, value: `0.5`, want: []string{"$: expected >= 1"}},
+		{name: "above maximum", schema: `{"maximum": 3}`, value: `4`, want: []string{"$: expected <= 3"}},
+		{name: "exclusive minimum", schema: `{"exclusiveMinimum": 1}`, value: `1`, want: []string{"$: expected > 1"}},
+		{name: "exclusive maximum", schema: `{"exclusiveMaximum": 3}`, value: `3`, want: []string{"$: expected < 3"}},
+		{name: "minItems", schema: `{"minItems": 2}`, value: `[1]`, want: []string{"$: expected at least 2 items"}},
+		{name: "maxItems", schema: `{"maxItems": 1}`, value: `[1, 2]`, want: []string{"$: expected at most 1 items"}},
+		{name: "items", schema: `{"items": {"type": "integer"}}`, value: `[1, "a", 2.5]`, want: []string{
+			"$[1]: expected integer, got string",
+			"$[2]: expected integer, got number",
+		}},
+
+		{name: "allOf", schema: `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, value: `5`, want: []string{"$: expected <= 3"}},
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"minimum": 10}]}`, value: `11`},
+		{name: "anyOf mismatch", schema: `{"anyOf": [{"type": "string"}, {"minimum": 10}]}`, value: `5`, want: []string{"$: does not match any of anyOf"}},
+		{name: "oneOf", schema: `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, value: `"a"`},
+		{name: "oneOf none", schema: `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, value: `true`, want: []string{"$: matches 0 of oneOf, expected exactly 1"}},
+		{name: "oneOf both", schema: `{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, value: `1`, want: []string{"$: matches 2 of oneOf, expected exactly 1"}},
+		{name: "not", schema: `{"not": {"type": "null"}}`, value: `1`},
+		{name: "not mismatch", schema: `{"not": {"type": "null"}}`, value: `null`, want: []string{"$: must not match schema in not"}},
+
+		{name: "every violation", schema: `{"type": "object", "required": ["id"], "properties": {"n": {"maximum": 1}}, "additionalProperties": false}`, value: `{"n": 2, "x": 1}`, want: []string{
+			`$: missing required property "id"`,
+			"$.n: expected <= 1",
+			`$: additional property "x" is not allowed`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile(tt.schema)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+
+			var value interface{}
+			if err = json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("invalid test value: %v", err)
+			}
+
+			if got := s.Validate(value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
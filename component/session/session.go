@@ -0,0 +1,248 @@
+//
+// File: session.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package session groups the events of a device into sessions split by a gap
+// of inactivity. The state of every open session is kept in redis, so that all
+// proxy instances agree on it, and each session is ended exactly once.
+package session
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// open sessions by member aid:did, scored by when they expire in unix ms
+	expiryKey = "session:expiry"
+
+	// how long the state of a session outlives its gap, in case no sweeper runs
+	stateGrace = 24 * time.Hour
+)
+
+// assignScript puts an event at ARGV[1] (unix ms) into the open session of the
+// device in KEYS[1], or starts session ARGV[3] when there is none or the gap
+// ARGV[2] has passed, by event time or by the time the device was last seen.
+// Events more than the gap older than the start of the open session are late,
+// they are left out and nil is returned. Otherwise it returns the session id,
+// whether it was started, and the id, start, last event time, event count and
+// last seen time of the session it replaced.
+var assignScript = redis.NewScript(`
+local t = tonumber(ARGV[1])
+local gap = tonumber(ARGV[2])
+
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+
+local s = redis.call('HMGET', KEYS[1], 'id', 'start', 'last', 'seen', 'events')
+if s[1] and t < tonumber(s[2]) - gap then
+	return false
+end
+
+if s[1] and t <= tonumber(s[3]) + gap and now <= tonumber(s[4]) + gap then
+	if t > tonumber(s[3]) then
+		redis.call('HSET', KEYS[1], 'last', ARGV[1])
+	end
+	redis.call('HSET', KEYS[1], 'seen', now)
+	redis.call('HINCRBY', KEYS[1], 'events', 1)
+	redis.call('PEXPIRE', KEYS[1], gap + tonumber(ARGV[5]))
+	redis.call('ZADD', KEYS[2], now + gap, ARGV[4])
+	return {s[1], 0}
+end
+
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'id', ARGV[3], 'start', ARGV[1], 'last', ARGV[1], 'seen', now, 'events', 1)
+redis.call('PEXPIRE', KEYS[1], gap + tonumber(ARGV[5]))
+redis.call('ZADD', KEYS[2], now + gap, ARGV[4])
+
+if s[1] then
+	return {ARGV[3], 1, s[1], s[2], s[3], s[5], s[4]}
+end
+return {ARGV[3], 1}
+`)
+
+// releaseScript undoes assignment ARGV[1] of the device in KEYS[1] as long as
+// its session is still the open one. A joined session gives back the event, a
+// started one is dropped and the session it replaced, ARGV[3] to ARGV[7], is
+// opened again.
+var releaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return 0
+end
+
+if ARGV[2] == '0' then
+	redis.call('HINCRBY', KEYS[1], 'events', -1)
+	return 1
+end
+
+redis.call('DEL', KEYS[1])
+if ARGV[3] == '' then
+	redis.call('ZREM', KEYS[2], ARGV[8])
+	return 1
+end
+
+local gap = tonumber(ARGV[9])
+redis.call('HSET', KEYS[1], 'id', ARGV[3], 'start', ARGV[4], 'last', ARGV[5], 'events', ARGV[6], 'seen', ARGV[7])
+redis.call('PEXPIRE', KEYS[1], gap + tonumber(ARGV[10]))
+redis.call('ZADD', KEYS[2], tonumber(ARGV[7]) + gap, ARGV[8])
+return 1
+`)
+
+// endScript ends the session of the device in KEYS[1] if it has been idle for
+// the gap ARGV[2], returning its id, start, last event time and event count.
+var endScript = redis.NewScript(`
+local gap = tonumber(ARGV[2])
+
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+
+local s = redis.call('HMGET', KEYS[1], 'id', 'start', 'last', 'seen', 'events')
+if not s[1] then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return false
+end
+
+if now < tonumber(s[4]) + gap then
+	redis.call('ZADD', KEYS[2], tonumber(s[4]) + gap, ARGV[1])
+	return false
+end
+
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return {s[1], s[2], s[3], s[5]}
+`)
+
+// Session is a finished session of a device.
+type Session struct {
+	ID     string
+	Aid    int
+	Did    string
+	Start  time.Time
+	Last   time.Time
+	Events int
+}
+
+// Assignment is the session an event was put into, ID is empty for late
+// events that were left out.
+type Assignment struct {
+	ID      string
+	Started bool
+	// Ended is the session of the device that was closed by this one starting
+	Ended *Session
+
+	// when the device of the ended session was last seen, in unix ms
+	endedSeen string
+}
+
+func member(aid int, did string) string {
+	return strconv.Itoa(aid) + ":" + did
+}
+
+func stateKey(member string) string {
+	return "session:" + member
+}
+
+func unixMilli(s interface{}) time.Time {
+	v, _ := strconv.ParseInt(s.(string), 10, 64)
+	return time.UnixMilli(v)
+}
+
+func toSession(aid int, did string, fields []interface{}) *Session {
+	events, _ := strconv.Atoi(fields[3].(string))
+	return &Session{
+		ID:     fields[0].(string),
+		Aid:    aid,
+		Did:    did,
+		Start:  unixMilli(fields[1]),
+		Last:   unixMilli(fields[2]),
+		Events: events,
+	}
+}
+
+// Assign puts an event of a device that happened at t into a session.
+func Assign(ctx context.Context, rdb redis.Scripter, aid int, did string, t time.Time, gap time.Duration) (a Assignment, err error) {
+	m := member(aid, did)
+	res, err := assignScript.Run(ctx, rdb, []string{stateKey(m), expiryKey},
+		t.UnixMilli(), gap.Milliseconds(), uuid.NewString(), m, stateGrace.Milliseconds()).Slice()
+	if errors.Is(err, redis.Nil) {
+		return a, nil
+	} else if err != nil {
+		return
+	}
+
+	a.ID = res[0].(string)
+	a.Started = res[1].(int64) == 1
+	if len(res) > 2 {
+		a.Ended = toSession(aid, did, res[2:6])
+		a.endedSeen = res[6].(string)
+	}
+
+	return
+}
+
+// Release undoes an assignment whose event was not written, so that the event
+// is not counted and the session it ended stays open. Assignments of a device
+// must be released in the reverse order they were made. Nothing is undone once
+// the session has been replaced.
+func Release(ctx context.Context, rdb redis.Scripter, aid int, did string, a Assignment, gap time.Duration) error {
+	if a.ID == "" {
+		return nil
+	}
+
+	started := "0"
+	if a.Started {
+		started = "1"
+	}
+
+	ended := []interface{}{"", "", "", "", ""}
+	if a.Ended != nil {
+		ended = []interface{}{a.Ended.ID, a.Ended.Start.UnixMilli(), a.Ended.Last.UnixMilli(), a.Ended.Events, a.endedSeen}
+	}
+
+	m := member(aid, did)
+	args := append([]interface{}{a.ID, started}, ended...)
+	args = append(args, m, gap.Milliseconds(), stateGrace.Milliseconds())
+	return releaseScript.Run(ctx, rdb, []string{stateKey(m), expiryKey}, args...).Err()
+}
+
+// Sweep ends up to max sessions that have been idle for the gap. Sessions
+// swept by another instance at the same time are returned by only one of them.
+func Sweep(ctx context.Context, rdb redis.Cmdable, gap time.Duration, max int64) ([]*Session, error) {
+	members, err := rdb.ZRangeByScore(ctx, expiryKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: max,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var ended []*Session
+	for _, m := range members {
+		// device ids may hold colons, aids do not
+		saids, did, _ := strings.Cut(m, ":")
+		aid, _ := strconv.Atoi(saids)
+
+		res, err := endScript.Run(ctx, rdb, []string{stateKey(m), expiryKey}, m, gap.Milliseconds()).Slice()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return ended, err
+		}
+
+		ended = append(ended, toSession(aid, did, res))
+	}
+
+	return ended, nil
+}
@@ -0,0 +1,77 @@
+//
+// File: file.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"oset/model"
+	"sync"
+
+	"github.com/natefinch/lumberjack"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultEventFile = "./events/events.ndjson"
+)
+
+// fileSink appends events as ndjson to a local file that is rotated by size.
+type fileSink struct {
+	name string
+
+	mu     sync.Mutex
+	writer *lumberjack.Logger
+}
+
+func newFileSink(name string) (EventSink, error) {
+	path := viper.GetString(key(name, "path"))
+	if path == "" {
+		path = defaultEventFile
+	}
+
+	return &fileSink{
+		name: name,
+		writer: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    viper.GetInt(key(name, "max_file_size")),
+			MaxBackups: viper.GetInt(key(name, "max_backups")),
+			MaxAge:     viper.GetInt(key(name, "max_age")),
+			Compress:   viper.GetBool(key(name, "is_compress")),
+		},
+	}, nil
+}
+
+func (f *fileSink) Name() string {
+	return f.name
+}
+
+func (f *fileSink) Write(events []model.Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range events {
+		if err := encoder.Encode(events[i]); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.writer.Write(buf.Bytes())
+	return err
+}
+
+func (f *fileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writer.Close()
+}
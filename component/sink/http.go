@@ -0,0 +1,102 @@
+//
+// File: http.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"oset/model"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultHTTPTimeout = 5 * time.Second
+)
+
+var (
+	ErrNoForwardURL = errors.New("http sink has no url")
+)
+
+// httpSink forwards events as an ndjson POST to another service.
+type httpSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPSink(name string) (EventSink, error) {
+	url := viper.GetString(key(name, "url"))
+	if url == "" {
+		return nil, ErrNoForwardURL
+	}
+
+	timeout := viper.GetDuration(key(name, "timeout"))
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	return &httpSink{
+		name:    name,
+		url:     url,
+		headers: viper.GetStringMapString(key(name, "headers")),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+func (h *httpSink) Name() string {
+	return h.name
+}
+
+func (h *httpSink) Write(events []model.Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range events {
+		if err := encoder.Encode(events[i]); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.url, &buf)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("forward events to %s failed with status %d", h.url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (h *httpSink) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,277 @@
+//
+// File: kafka.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oset/model"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/Shopify/sarama"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultKafkaTopic      = "events"
+	defaultReconnectPeriod = 5 * time.Second
+)
+
+// how the message key of an event is made, so that the events sharing a key
+// land on the same partition in order
+const (
+	kafkaKeyNone     = "none"
+	kafkaKeyAid      = "aid"
+	kafkaKeyAidDid   = "aid_did"
+	kafkaKeyProperty = "property"
+)
+
+var (
+	ErrKafkaUnavailable = errors.New("kafka is unavailable")
+	ErrInvalidKafkaKey  = errors.New("invalid kafka key, must be none, aid, aid_did or property")
+)
+
+// kafkaSink writes events to the topic picked by its routes. A producer is
+// created for each topic the first time an event is routed to it. Messages
+// are keyed as set by the key option, aid+did by default, so that the events
+// of a device are consumed in order.
+type kafkaSink struct {
+	name            string
+	hosts           []string
+	router          topicRouter
+	keyMode         string
+	keyProperty     string
+	reconnectPeriod time.Duration
+
+	mu        sync.Mutex
+	producers map[string]*topicProducer
+}
+
+type topicProducer struct {
+	producer    sarama.SyncProducer
+	lastAttempt time.Time
+}
+
+func newKafkaSink(name string) (EventSink, error) {
+	host := viper.GetString(key(name, "host"))
+	if host == "" {
+		host = viper.GetString("kafka.host")
+	}
+
+	k := &kafkaSink{
+		name:  name,
+		hosts: strings.Split(host, ","),
+		router: topicRouter{
+			fallback: viper.GetString(key(name, "topic")),
+		},
+		keyMode:         viper.GetString(key(name, "key")),
+		keyProperty:     viper.GetString(key(name, "key_property")),
+		reconnectPeriod: viper.GetDuration(key(name, "reconnect_period")),
+		producers:       make(map[string]*topicProducer),
+	}
+
+	switch k.keyMode {
+	case "":
+		k.keyMode = kafkaKeyAidDid
+	case kafkaKeyNone, kafkaKeyAid, kafkaKeyAidDid:
+	case kafkaKeyProperty:
+		if k.keyProperty == "" {
+			return nil, fmt.Errorf("%w: key_property is not set", ErrInvalidKafkaKey)
+		}
+	default:
+		return nil, ErrInvalidKafkaKey
+	}
+
+	if k.router.fallback == "" {
+		k.router.fallback = defaultKafkaTopic
+	}
+	if k.reconnectPeriod <= 0 {
+		k.reconnectPeriod = defaultReconnectPeriod
+	}
+
+	err := viper.UnmarshalKey(key(name, "routes"), &k.router.routes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRoute, err.Error())
+	}
+
+	for i := range k.router.routes {
+		if err = k.router.routes[i].check(); err != nil {
+			return nil, err
+		}
+	}
+
+	// a broker that is unreachable at startup is not fatal, the producer is
+	// created once kafka is back
+	if _, err := k.getProducer(k.router.fallback); err != nil {
+		etlog.L().Warn("kafka is unavailable", zap.String("sink", name), zap.Error(err))
+	}
+
+	return k, nil
+}
+
+func (k *kafkaSink) Name() string {
+	return k.name
+}
+
+// messageKey returns the key of an event, nil when events are not keyed. An
+// event without the key property is keyed by its device.
+func (k *kafkaSink) messageKey(event *model.Event, data func() map[string]interface{}) sarama.Encoder {
+	aid := strconv.Itoa(event.Aid)
+	switch k.keyMode {
+	case kafkaKeyNone:
+		return nil
+	case kafkaKeyAid:
+		return sarama.StringEncoder(aid)
+	case kafkaKeyProperty:
+		if value, ok := model.DataProperty(data(), k.keyProperty); ok {
+			return sarama.StringEncoder(aid + ":" + value)
+		}
+	}
+
+	return sarama.StringEncoder(aid + ":" + string(event.Did))
+}
+
+// messageHeaders lets consumers filter events without decoding them.
+func messageHeaders(event *model.Event) []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte("aid"), Value: []byte(strconv.Itoa(event.Aid))},
+		{Key: []byte("event"), Value: []byte(event.Event)},
+		{Key: []byte("schema_version"), Value: []byte(strconv.Itoa(event.SchemaVersion))},
+	}
+}
+
+// getProducer returns the producer of a topic, connecting to kafka first if
+// needed. Reconnecting is attempted at most once per reconnect period.
+func (k *kafkaSink) getProducer(topic string) (sarama.SyncProducer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	tp, ok := k.producers[topic]
+	if !ok {
+		tp = &topicProducer{}
+		k.producers[topic] = tp
+	}
+
+	if tp.producer != nil {
+		return tp.producer, nil
+	}
+
+	if time.Since(tp.lastAttempt) < k.reconnectPeriod {
+		return nil, ErrKafkaUnavailable
+	}
+	tp.lastAttempt = time.Now()
+
+	kconfig := sarama.NewConfig()
+	kconfig.Producer.RequiredAcks = sarama.WaitForLocal
+	kconfig.Producer.Return.Successes = true
+	kconfig.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(k.hosts, kconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tp.producer = producer
+	etlog.L().Info("created kafka producer", zap.String("sink", k.name), zap.String("topic", topic))
+	return tp.producer, nil
+}
+
+// Write sends events with one produce call per topic they are routed to.
+func (k *kafkaSink) Write(events []model.Event) error {
+	var topics []string
+	byTopic := make(map[string][]*sarama.ProducerMessage)
+	for i := range events {
+		jevent, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+
+		data := events[i].LazyData()
+		topic := k.router.topic(&events[i], data)
+		if _, ok := byTopic[topic]; !ok {
+			topics = append(topics, topic)
+		}
+
+		byTopic[topic] = append(byTopic[topic], &sarama.ProducerMessage{
+			Topic:    topic,
+			Key:      k.messageKey(&events[i], data),
+			Value:    sarama.ByteEncoder(jevent),
+			Headers:  messageHeaders(&events[i]),
+			Metadata: i,
+		})
+	}
+
+	var (
+		failed  []int
+		lastErr error
+	)
+	for _, topic := range topics {
+		msgs := byTopic[topic]
+
+		producer, err := k.getProducer(topic)
+		if err == nil {
+			err = producer.SendMessages(msgs)
+		}
+		if err == nil {
+			continue
+		}
+		lastErr = err
+
+		var perrs sarama.ProducerErrors
+		if errors.As(err, &perrs) {
+			for _, perr := range perrs {
+				failed = append(failed, perr.Msg.Metadata.(int))
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			failed = append(failed, msg.Metadata.(int))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	} else if len(failed) == len(events) {
+		return lastErr
+	}
+
+	sort.Ints(failed)
+	return &PartialError{
+		Failed: failed,
+		Err:    lastErr,
+	}
+}
+
+func (k *kafkaSink) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var err error
+	for topic, tp := range k.producers {
+		if tp.producer == nil {
+			continue
+		}
+
+		if cerr := tp.producer.Close(); cerr != nil {
+			etlog.L().Warn("failed to close kafka producer", zap.String("sink", k.name), zap.String("topic", topic), zap.Error(cerr))
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,75 @@
+//
+// File: mysql.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"encoding/json"
+	"oset/db"
+	"oset/model"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultEventTable = "event_rows"
+	mysqlBatchSize    = 500
+)
+
+// mysqlSink inserts events into a table of model.EventRow.
+type mysqlSink struct {
+	name  string
+	table string
+}
+
+func newMysqlSink(name string) (EventSink, error) {
+	table := viper.GetString(key(name, "table"))
+	if table == "" {
+		table = defaultEventTable
+	}
+
+	if err := db.Mysql().Table(table).AutoMigrate(&model.EventRow{}); err != nil {
+		return nil, err
+	}
+
+	return &mysqlSink{
+		name:  name,
+		table: table,
+	}, nil
+}
+
+func (m *mysqlSink) Name() string {
+	return m.name
+}
+
+func (m *mysqlSink) Write(events []model.Event) error {
+	rows := make([]model.EventRow, 0, len(events))
+	for i := range events {
+		payload, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, model.EventRow{
+			Aid:     events[i].Aid,
+			Did:     string(events[i].Did),
+			UserID:  events[i].UserID,
+			Event:   events[i].Event,
+			Data:    events[i].Data,
+			Time:    events[i].Time,
+			Payload: string(payload),
+		})
+	}
+
+	return db.Mysql().Table(m.table).CreateInBatches(rows, mysqlBatchSize).Error
+}
+
+func (m *mysqlSink) Close() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+//
+// File: route.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"oset/model"
+	"path"
+)
+
+var (
+	ErrInvalidRoute = errors.New("invalid route")
+)
+
+// route sends the events matching it to Topic. An event matches when it meets
+// every condition that is set: its aid is one of Aids, its name matches the
+// glob Event, and the Data property at the dot separated path Property is set
+// and, if Values are given, equal to one of them.
+type route struct {
+	Topic    string   `mapstructure:"topic"`
+	Aids     []int    `mapstructure:"aids"`
+	Event    string   `mapstructure:"event"`
+	Property string   `mapstructure:"property"`
+	Values   []string `mapstructure:"values"`
+}
+
+func (r *route) check() error {
+	if r.Topic == "" {
+		return fmt.Errorf("%w: no topic", ErrInvalidRoute)
+	}
+
+	if _, err := path.Match(r.Event, ""); err != nil {
+		return fmt.Errorf("%w: event pattern %q: %s", ErrInvalidRoute, r.Event, err.Error())
+	}
+
+	return nil
+}
+
+func (r *route) match(event *model.Event, data func() map[string]interface{}) bool {
+	if len(r.Aids) > 0 {
+		found := false
+		for _, aid := range r.Aids {
+			if aid == event.Aid {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if r.Event != "" {
+		if ok, _ := path.Match(r.Event, event.Event); !ok {
+			return false
+		}
+	}
+
+	if r.Property != "" {
+		value, ok := model.DataProperty(data(), r.Property)
+		if !ok {
+			return false
+		}
+
+		if len(r.Values) > 0 {
+			found := false
+			for _, v := range r.Values {
+				if v == value {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// topicRouter picks the topic of an event from the first route it matches,
+// falling back to a default topic.
+type topicRouter struct {
+	routes   []route
+	fallback string
+}
+
+func (tr *topicRouter) topic(event *model.Event, data func() map[string]interface{}) string {
+	for i := range tr.routes {
+		if tr.routes[i].match(event, data) {
+			return tr.routes[i].Topic
+		}
+	}
+
+	return tr.fallback
+}
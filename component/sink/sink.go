@@ -0,0 +1,179 @@
+//
+// File: sink.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"oset/component/spool"
+	"oset/model"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSpoolDir = "./spool"
+)
+
+var (
+	ErrUnknownSinkType = errors.New("unknown sink type")
+	ErrNoSink          = errors.New("no event sink is enabled")
+)
+
+// EventSink is a destination prepared events are written to.
+type EventSink interface {
+	Name() string
+	// Write delivers events in order. A sink that delivered some of them
+	// reports the rest with a *PartialError.
+	Write(events []model.Event) error
+	Close() error
+}
+
+// PartialError reports which events of a Write were not delivered, by index.
+type PartialError struct {
+	Failed []int
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d events were not delivered: %s", len(e.Failed), e.Err.Error())
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// FanOutError reports the sinks a Write failed on. Accepted names the sinks
+// that took the events all the same, which a retry would duplicate them in.
+type FanOutError struct {
+	Accepted []string
+	Failed   []string
+	msgs     []string
+}
+
+func (e *FanOutError) Error() string {
+	return strings.Join(e.msgs, "; ")
+}
+
+// factory builds the sink configured under sink.<name>.
+type factory func(name string) (EventSink, error)
+
+var (
+	factories = map[string]factory{
+		"kafka": newKafkaSink,
+		"file":  newFileSink,
+		"http":  newHTTPSink,
+		"mysql": newMysqlSink,
+		"store": newStoreSink,
+	}
+
+	sinks []EventSink
+	once  sync.Once
+)
+
+// key returns the config key of an option of a sink.
+func key(name string, option string) string {
+	return "sink." + name + "." + option
+}
+
+// Init builds the sinks listed in sink.enabled. Each entry names a section
+// sink.<name> whose type option selects the implementation, defaulting to the
+// name itself, so several sinks of the same type can be combined.
+func Init() {
+	once.Do(func() {
+		names := viper.GetStringSlice("sink.enabled")
+		if len(names) == 0 {
+			names = []string{"kafka"}
+		}
+
+		for _, name := range names {
+			typ := viper.GetString(key(name, "type"))
+			if typ == "" {
+				typ = name
+			}
+
+			f, ok := factories[typ]
+			if !ok {
+				etlog.L().Panic("failed to create event sink", zap.String("sink", name), zap.Error(fmt.Errorf("%w: %s", ErrUnknownSinkType, typ)))
+			}
+
+			s, err := f(name)
+			if err != nil {
+				etlog.L().Panic("failed to create event sink", zap.String("sink", name), zap.String("type", typ), zap.Error(err))
+			}
+
+			if viper.GetBool(key(name, "spool")) {
+				dir := viper.GetString("spool.dir")
+				if dir == "" {
+					dir = defaultSpoolDir
+				}
+
+				s, err = newSpooledSink(s, filepath.Join(dir, name))
+				if err != nil {
+					etlog.L().Panic("failed to open event spool", zap.String("sink", name), zap.Error(err))
+				}
+			}
+
+			sinks = append(sinks, s)
+			etlog.L().Info("event sink enabled", zap.String("sink", name), zap.String("type", typ))
+		}
+	})
+}
+
+// Write fans events out to every enabled sink. All sinks are tried even if
+// some of them fail, which is reported with a *FanOutError.
+func Write(events []model.Event) error {
+	if len(sinks) == 0 {
+		return ErrNoSink
+	}
+
+	fe := &FanOutError{}
+	for _, s := range sinks {
+		if err := s.Write(events); err != nil {
+			etlog.L().Error("failed to write events to sink", zap.String("sink", s.Name()), zap.Int("size", len(events)), zap.Error(err))
+			fe.Failed = append(fe.Failed, s.Name())
+			fe.msgs = append(fe.msgs, s.Name()+": "+err.Error())
+		} else {
+			fe.Accepted = append(fe.Accepted, s.Name())
+		}
+	}
+
+	if len(fe.Failed) > 0 {
+		return fe
+	}
+
+	return nil
+}
+
+// SpoolStats returns the spool of every sink that has one, by sink name.
+func SpoolStats() map[string]spool.Stats {
+	stats := make(map[string]spool.Stats)
+	for _, s := range sinks {
+		if ss, ok := s.(*spooledSink); ok {
+			stats[s.Name()] = ss.spool.Stats()
+		}
+	}
+
+	return stats
+}
+
+// Close closes every sink. Spooled events are kept on disk.
+func Close() {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			etlog.L().Warn("failed to close event sink", zap.String("sink", s.Name()), zap.Error(err))
+		}
+	}
+}
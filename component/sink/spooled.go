@@ -0,0 +1,203 @@
+//
+// File: spooled.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"oset/component/spool"
+	"oset/model"
+	"sync"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSpoolDrainBatch    = 500
+	defaultSpoolRetryInterval = 5 * time.Second
+)
+
+// spooledSink puts a disk spool in front of a sink. Events the sink fails to
+// take are spooled and drained in order once it recovers. While the spool is
+// not empty new events are queued behind it. Delivery is at least once.
+type spooledSink struct {
+	EventSink
+
+	spool         *spool.Spool
+	drainBatch    int
+	retryInterval time.Duration
+
+	// mu keeps a direct write, a spool append and the drain of a batch from
+	// interleaving, so that events reach the sink in order
+	mu sync.Mutex
+
+	// stop ends the drain, which closes done once it has returned
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSpooledSink(inner EventSink, dir string) (EventSink, error) {
+	s, err := spool.Open(dir, viper.GetInt64("spool.max_segment_size"))
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &spooledSink{
+		EventSink:     inner,
+		spool:         s,
+		drainBatch:    viper.GetInt("spool.drain_batch"),
+		retryInterval: viper.GetDuration("spool.retry_interval"),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if ss.drainBatch <= 0 {
+		ss.drainBatch = defaultSpoolDrainBatch
+	}
+	if ss.retryInterval <= 0 {
+		ss.retryInterval = defaultSpoolRetryInterval
+	}
+
+	if depth := s.Depth(); depth > 0 {
+		etlog.L().Info("found spooled events", zap.String("sink", inner.Name()), zap.Int64("spool_depth", depth))
+	}
+
+	go ss.drain()
+	return ss, nil
+}
+
+func (ss *spooledSink) Write(events []model.Event) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.spool.Depth() == 0 {
+		err := ss.EventSink.Write(events)
+		if err == nil {
+			return nil
+		}
+
+		// only the events that were not delivered need to be spooled
+		var perr *PartialError
+		if errors.As(err, &perr) {
+			failed := make([]model.Event, 0, len(perr.Failed))
+			for _, i := range perr.Failed {
+				failed = append(failed, events[i])
+			}
+			events = failed
+		}
+
+		etlog.L().Warn("failed to write events to sink, spooling them", zap.String("sink", ss.Name()), zap.Int("size", len(events)), zap.Error(err))
+	}
+
+	payloads := make([][]byte, 0, len(events))
+	for i := range events {
+		jevent, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, jevent)
+	}
+
+	return ss.spool.Append(payloads)
+}
+
+// sleep waits for d, returning false once the sink is closed.
+func (ss *spooledSink) sleep(d time.Duration) bool {
+	select {
+	case <-ss.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (ss *spooledSink) drain() {
+	defer close(ss.done)
+
+	for {
+		select {
+		case <-ss.stop:
+			return
+		default:
+		}
+
+		wait, closed := ss.drainOnce()
+		if closed {
+			return
+		}
+
+		if wait > 0 && !ss.sleep(wait) {
+			return
+		}
+	}
+}
+
+// drainOnce delivers the oldest batch of the spool, returning how long to wait
+// before the next one and whether the spool was closed.
+func (ss *spooledSink) drainOnce() (wait time.Duration, closed bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.spool.Depth() == 0 {
+		return time.Second, false
+	}
+
+	batch, err := ss.spool.Peek(ss.drainBatch)
+	if err != nil {
+		if errors.Is(err, spool.ErrClosed) {
+			return 0, true
+		}
+
+		etlog.L().Error("failed to read event spool", zap.String("sink", ss.Name()), zap.Error(err))
+		return ss.retryInterval, false
+	}
+
+	events := make([]model.Event, 0, len(batch.Records))
+	for _, rec := range batch.Records {
+		var event model.Event
+		if err := json.Unmarshal(rec.Data, &event); err != nil {
+			etlog.L().Error("dropped unreadable spooled event", zap.String("sink", ss.Name()), zap.ByteString("record", rec.Data), zap.Error(err))
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if len(events) > 0 {
+		if err = ss.EventSink.Write(events); err != nil {
+			etlog.L().Warn("failed to drain event spool", zap.String("sink", ss.Name()), zap.Int64("spool_depth", ss.spool.Depth()), zap.Error(err))
+			return ss.retryInterval, false
+		}
+	}
+
+	if err = ss.spool.Commit(batch); err != nil {
+		etlog.L().Error("failed to commit event spool", zap.String("sink", ss.Name()), zap.Error(err))
+		return ss.retryInterval, false
+	}
+
+	return 0, false
+}
+
+// Close stops the drain and closes the spool, events still spooled are drained
+// on the next start.
+func (ss *spooledSink) Close() error {
+	ss.stopOnce.Do(func() { close(ss.stop) })
+	<-ss.done
+
+	err := ss.spool.Close()
+	if cerr := ss.EventSink.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,55 @@
+//
+// File: store.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package sink
+
+import (
+	"oset/component/store"
+	"oset/model"
+
+	"github.com/spf13/viper"
+)
+
+// storeSink feeds the built-in event store that /event/query reads from.
+type storeSink struct {
+	name string
+}
+
+func newStoreSink(name string) (EventSink, error) {
+	table := viper.GetString(key(name, "table"))
+	if table == "" {
+		table = store.DefaultTable
+	}
+
+	retention := store.DefaultRetention
+	if viper.IsSet(key(name, "retention_days")) {
+		retention = viper.GetInt(key(name, "retention_days"))
+	}
+
+	if err := store.Open(table, retention); err != nil {
+		return nil, err
+	}
+
+	return &storeSink{
+		name: name,
+	}, nil
+}
+
+func (s *storeSink) Name() string {
+	return s.name
+}
+
+func (s *storeSink) Write(events []model.Event) error {
+	return store.Write(events)
+}
+
+func (s *storeSink) Close() error {
+	store.Close()
+	return nil
+}
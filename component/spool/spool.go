@@ -0,0 +1,478 @@
+//
+// File: spool.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+
+	// length, crc32 and unix nano timestamp
+	headerSize = 4 + 4 + 8
+
+	DefaultMaxSegmentSize = 64 << 20
+)
+
+var (
+	ErrClosed        = errors.New("spool is closed")
+	ErrCorruptRecord = errors.New("spool record is corrupt")
+)
+
+// Record is one spooled payload with the time it was appended.
+type Record struct {
+	Time time.Time
+	Data []byte
+}
+
+// Position points at a record inside the spool.
+type Position struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Batch is a run of records read from the head of the spool. It is removed
+// from the spool only once it is passed to Commit.
+type Batch struct {
+	Records []Record
+
+	next Position
+	size int64
+}
+
+// Stats describes how much is waiting in the spool.
+type Stats struct {
+	Depth    int64     `json:"depth"`
+	Bytes    int64     `json:"bytes"`
+	Segments int       `json:"segments"`
+	Oldest   time.Time `json:"oldest"`
+}
+
+// Spool is an append only, disk backed fifo queue. Records are written to
+// numbered segment files and a cursor file remembers how far they have been
+// consumed, so that the content survives a restart of the process.
+type Spool struct {
+	mu sync.Mutex
+
+	dir            string
+	maxSegmentSize int64
+
+	segments  []uint64
+	writer    *os.File
+	writeSize int64
+
+	cursor Position
+	depth  int64
+	bytes  int64
+	closed bool
+}
+
+// Open opens the spool stored in dir, creating it if needed. A record that was
+// only partly written when the process stopped is discarded.
+func Open(dir string, maxSegmentSize int64) (*Spool, error) {
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = DefaultMaxSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, id)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if err = s.loadCursor(); err != nil {
+		return nil, err
+	}
+
+	// segments before the cursor have been fully consumed
+	for len(s.segments) > 0 && s.segments[0] < s.cursor.Segment {
+		os.Remove(s.segmentPath(s.segments[0]))
+		s.segments = s.segments[1:]
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, s.cursor.Segment)
+	} else if s.cursor.Segment < s.segments[0] {
+		s.cursor = Position{Segment: s.segments[0]}
+	}
+
+	for i, id := range s.segments {
+		offset := int64(0)
+		if id == s.cursor.Segment {
+			offset = s.cursor.Offset
+		}
+
+		end, count, size, err := s.scan(id, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == len(s.segments)-1 {
+			if err = os.Truncate(s.segmentPath(id), end); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		s.depth += count
+		s.bytes += size
+	}
+
+	if err = s.openWriter(s.segments[len(s.segments)-1]); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func (s *Spool) loadCursor() error {
+	s.cursor = Position{Segment: 1}
+	if len(s.segments) > 0 {
+		s.cursor.Segment = s.segments[0]
+	}
+
+	content, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var pos Position
+	if _, err = fmt.Sscanf(string(content), "%d %d", &pos.Segment, &pos.Offset); err != nil {
+		return fmt.Errorf("invalid spool cursor: %w", err)
+	}
+
+	s.cursor = pos
+	return nil
+}
+
+func (s *Spool) saveCursor() error {
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+
+	content := fmt.Sprintf("%d %d\n", s.cursor.Segment, s.cursor.Offset)
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// scan walks the records of a segment from offset and returns where the last
+// complete record ends.
+func (s *Spool) scan(id uint64, offset int64) (end int64, count int64, size int64, err error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, 0, nil
+		}
+		return
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	end = offset
+	reader := bufio.NewReader(f)
+	for {
+		_, n, rerr := readRecord(reader)
+		if rerr != nil {
+			// a short or corrupt tail is what a crash during append leaves behind
+			return end, count, size, nil
+		}
+
+		end += n
+		size += n
+		count++
+	}
+}
+
+func readRecord(reader io.Reader) (rec Record, n int64, err error) {
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	stamp := int64(binary.BigEndian.Uint64(header[8:16]))
+
+	data := make([]byte, length)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return
+	}
+
+	if crc32.ChecksumIEEE(data) != sum {
+		err = ErrCorruptRecord
+		return
+	}
+
+	rec = Record{
+		Time: time.Unix(0, stamp),
+		Data: data,
+	}
+	n = int64(headerSize) + int64(length)
+	return
+}
+
+func (s *Spool) openWriter(id uint64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.writer = f
+	s.writeSize = info.Size()
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+
+	id := s.segments[len(s.segments)-1] + 1
+	if err := s.openWriter(id); err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+// Append writes records at the tail of the spool and syncs them to disk.
+func (s *Spool) Append(payloads [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.writeSize >= s.maxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UnixNano()
+	var buf []byte
+	for _, payload := range payloads {
+		header := make([]byte, headerSize)
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+		binary.BigEndian.PutUint64(header[8:16], uint64(now))
+
+		buf = append(buf, header...)
+		buf = append(buf, payload...)
+	}
+
+	if _, err := s.writer.Write(buf); err != nil {
+		return err
+	}
+
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+
+	s.writeSize += int64(len(buf))
+	s.depth += int64(len(payloads))
+	s.bytes += int64(len(buf))
+	return nil
+}
+
+// Peek reads up to max records from the head of the spool without removing them.
+func (s *Spool) Peek(max int) (*Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	batch := &Batch{next: s.cursor}
+	for i, id := range s.segments {
+		if id < batch.next.Segment || len(batch.Records) >= max {
+			continue
+		}
+
+		offset := int64(0)
+		if id == batch.next.Segment {
+			offset = batch.next.Offset
+		}
+
+		f, err := os.Open(s.segmentPath(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		reader := bufio.NewReader(f)
+		for len(batch.Records) < max {
+			rec, n, err := readRecord(reader)
+			if err != nil {
+				break
+			}
+
+			batch.Records = append(batch.Records, rec)
+			batch.size += n
+			offset += n
+		}
+		f.Close()
+
+		batch.next = Position{Segment: id, Offset: offset}
+		if len(batch.Records) < max && i < len(s.segments)-1 {
+			batch.next = Position{Segment: s.segments[i+1]}
+		}
+	}
+
+	return batch, nil
+}
+
+// Commit removes a batch returned by Peek from the spool.
+func (s *Spool) Commit(batch *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.cursor = batch.next
+	if err := s.saveCursor(); err != nil {
+		return err
+	}
+
+	s.depth -= int64(len(batch.Records))
+	s.bytes -= batch.size
+
+	for len(s.segments) > 1 && s.segments[0] < s.cursor.Segment {
+		os.Remove(s.segmentPath(s.segments[0]))
+		s.segments = s.segments[1:]
+	}
+
+	return nil
+}
+
+// Depth returns the number of records waiting in the spool.
+func (s *Spool) Depth() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.depth
+}
+
+// Stats returns the size of the spool and the time its oldest record was appended.
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Depth:    s.depth,
+		Bytes:    s.bytes,
+		Segments: len(s.segments),
+	}
+
+	if s.depth == 0 || s.closed {
+		return stats
+	}
+
+	for _, id := range s.segments {
+		if id < s.cursor.Segment {
+			continue
+		}
+
+		f, err := os.Open(s.segmentPath(id))
+		if err != nil {
+			break
+		}
+
+		header := make([]byte, headerSize)
+		offset := int64(0)
+		if id == s.cursor.Segment {
+			offset = s.cursor.Offset
+		}
+
+		_, err = f.ReadAt(header, offset)
+		f.Close()
+		if err == nil {
+			stats.Oldest = time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16])))
+			break
+		}
+	}
+
+	return stats
+}
+
+// Close flushes and closes the spool. Records left in it are kept on disk.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	return s.writer.Close()
+}
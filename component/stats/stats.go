@@ -0,0 +1,390 @@
+//
+// File: stats.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package stats keeps event counts and unique devices per app and event in
+// redis, bucketed by minute, hour and day. Counts are kept in a hash per
+// bucket, unique devices in a HyperLogLog per bucket, event and group.
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oset/model"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+
+	MetricCount   = "count"
+	MetricDevices = "devices"
+
+	// AllEvents counts every event an app reported, leaving out the synthetic
+	// events the server emits itself, such as $session_start, which are named
+	// with a leading $
+	AllEvents = "$all"
+
+	// MaxPoints is the most buckets a series may span
+	MaxPoints = 1440
+
+	// values of a dimension are cut to this many bytes
+	maxValueLength = 64
+
+	// sep joins the parts of a hash field, it does not show up in event names
+	sep = "\x1f"
+)
+
+var (
+	ErrInvalidGranularity = errors.New("granularity must be minute, hour or day")
+	ErrInvalidMetric      = errors.New("metric must be count or devices")
+	ErrInvalidRange       = errors.New("invalid time range")
+	ErrTooManyPoints      = fmt.Errorf("a series may have at most %d points", MaxPoints)
+	ErrUnknownDimension   = errors.New("unknown dimension")
+)
+
+var layouts = map[string]string{
+	GranularityMinute: "200601021504",
+	GranularityHour:   "2006010215",
+	GranularityDay:    "20060102",
+}
+
+// Granularities are the bucket sizes every event is counted in.
+var Granularities = []string{GranularityMinute, GranularityHour, GranularityDay}
+
+// Config is how events are counted. Dimensions are the properties series can
+// be grouped by: $os, $browser, $device_type, $country, $region and $city are
+// taken from the enrichment of an event, any other name is a dot path into its
+// data. TTL is how long the buckets of each granularity are kept.
+type Config struct {
+	Dimensions []string
+	TTL        map[string]time.Duration
+	Location   *time.Location
+}
+
+// truncate returns the start of the bucket t falls in.
+func truncate(t time.Time, granularity string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case GranularityMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+func next(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityMinute:
+		return t.Add(time.Minute)
+	case GranularityHour:
+		return t.Add(time.Hour)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+func countKey(aid int, granularity string, bucket time.Time) string {
+	return "stats:" + strconv.Itoa(aid) + ":" + granularity + ":" + bucket.Format(layouts[granularity])
+}
+
+func devicesKey(aid int, granularity string, bucket time.Time, field string) string {
+	return "stats:uv:" + strconv.Itoa(aid) + ":" + granularity + ":" + bucket.Format(layouts[granularity]) + ":" + field
+}
+
+// field names the counter of an event, or of one value of a dimension of it.
+func field(event string, dimension string, value string) string {
+	if dimension == "" {
+		return event
+	}
+
+	return event + sep + dimension + sep + value
+}
+
+func dimensionValue(event *model.Event, dimension string, data func() map[string]interface{}) (string, bool) {
+	var v string
+	switch dimension {
+	case "$os":
+		if event.UA != nil {
+			v = event.UA.OS
+		}
+	case "$browser":
+		if event.UA != nil {
+			v = event.UA.Browser
+		}
+	case "$device_type":
+		if event.UA != nil {
+			v = event.UA.DeviceType
+		}
+	case "$country":
+		if event.Geo != nil {
+			v = event.Geo.Country
+		}
+	case "$region":
+		if event.Geo != nil {
+			v = event.Geo.Region
+		}
+	case "$city":
+		if event.Geo != nil {
+			v = event.Geo.City
+		}
+	default:
+		v, _ = model.DataProperty(data(), dimension)
+	}
+
+	if v == "" {
+		return "", false
+	}
+	if len(v) > maxValueLength {
+		v = v[:maxValueLength]
+	}
+
+	return v, true
+}
+
+// Record counts events. Sampled events count as many events as they stand
+// for, unique devices are counted as reported.
+func Record(ctx context.Context, rdb redis.Cmdable, cfg *Config, events []model.Event) error {
+	return record(ctx, rdb, cfg, events, true)
+}
+
+// RecordDevices counts only the unique devices of events, for events that are
+// not written such as those dropped by sampling.
+func RecordDevices(ctx context.Context, rdb redis.Cmdable, cfg *Config, events []model.Event) error {
+	return record(ctx, rdb, cfg, events, false)
+}
+
+func record(ctx context.Context, rdb redis.Cmdable, cfg *Config, events []model.Event, counts bool) error {
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		expires := make(map[string]time.Duration)
+		for i := range events {
+			event := &events[i]
+			weight := 1.0
+			if event.SampleRate > 0 {
+				weight = 1 / event.SampleRate
+			}
+
+			names := []string{event.Event}
+			if !strings.HasPrefix(event.Event, "$") {
+				names = append(names, AllEvents)
+			}
+
+			var fields []string
+			for _, name := range names {
+				fields = append(fields, field(name, "", ""))
+			}
+
+			data := event.LazyData()
+			for _, dim := range cfg.Dimensions {
+				if v, ok := dimensionValue(event, dim, data); ok {
+					for _, name := range names {
+						fields = append(fields, field(name, dim, v))
+					}
+				}
+			}
+
+			for _, granularity := range Granularities {
+				bucket := truncate(event.Time, granularity, cfg.Location)
+				ttl := cfg.TTL[granularity]
+
+				key := countKey(event.Aid, granularity, bucket)
+				if counts {
+					expires[key] = ttl
+				}
+				for _, f := range fields {
+					if counts {
+						p.HIncrByFloat(ctx, key, f, weight)
+					}
+
+					if event.Did != "" {
+						uvKey := devicesKey(event.Aid, granularity, bucket, f)
+						p.PFAdd(ctx, uvKey, string(event.Did))
+						expires[uvKey] = ttl
+					}
+				}
+			}
+		}
+
+		for key, ttl := range expires {
+			p.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// Point is the value of a series in the bucket starting at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series is the points of one group, Group is empty when not grouped.
+type Series struct {
+	Group  string  `json:"group"`
+	Points []Point `json:"points"`
+}
+
+// Query selects a series of an app, Event is AllEvents for every event. From
+// and To are rounded down to their buckets, both of which are included.
+type Query struct {
+	Aid         int
+	Event       string
+	Metric      string
+	Granularity string
+	From        time.Time
+	To          time.Time
+	GroupBy     string
+}
+
+// Check reports whether a query can be answered under cfg.
+func (q *Query) Check(cfg *Config) error {
+	if _, ok := layouts[q.Granularity]; !ok {
+		return ErrInvalidGranularity
+	}
+
+	if q.Metric != MetricCount && q.Metric != MetricDevices {
+		return ErrInvalidMetric
+	}
+
+	if q.From.IsZero() || q.To.IsZero() || q.To.Before(q.From) {
+		return ErrInvalidRange
+	}
+
+	points := 0
+	for t := truncate(q.From, q.Granularity, cfg.Location); !t.After(q.To); t = next(t, q.Granularity) {
+		if points++; points > MaxPoints {
+			return ErrTooManyPoints
+		}
+	}
+
+	if q.GroupBy != "" {
+		known := false
+		for _, dim := range cfg.Dimensions {
+			known = known || dim == q.GroupBy
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", ErrUnknownDimension, q.GroupBy)
+		}
+	}
+
+	return nil
+}
+
+// Run returns the series of a checked query. Grouped queries return a series
+// for every value seen within the range, in order of the value.
+func Run(ctx context.Context, rdb redis.Cmdable, cfg *Config, q *Query) ([]Series, error) {
+	var buckets []time.Time
+	for t := truncate(q.From, q.Granularity, cfg.Location); !t.After(q.To); t = next(t, q.Granularity) {
+		buckets = append(buckets, t)
+	}
+
+	// the counter hashes give both the counts and the values of the group
+	hashes := make([]*redis.MapStringStringCmd, len(buckets))
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, bucket := range buckets {
+			hashes[i] = p.HGetAll(ctx, countKey(q.Aid, q.Granularity, bucket))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{""}
+	if q.GroupBy != "" {
+		prefix := field(q.Event, q.GroupBy, "")
+		seen := make(map[string]bool)
+		groups = nil
+		for _, h := range hashes {
+			for f := range h.Val() {
+				if !strings.HasPrefix(f, prefix) {
+					continue
+				}
+
+				if v := f[len(prefix):]; !seen[v] {
+					seen[v] = true
+					groups = append(groups, v)
+				}
+			}
+		}
+		sort.Strings(groups)
+	}
+
+	series := make([]Series, len(groups))
+	for g, group := range groups {
+		f := field(q.Event, q.GroupBy, group)
+		series[g] = Series{
+			Group:  group,
+			Points: make([]Point, len(buckets)),
+		}
+
+		var uv []*redis.IntCmd
+		if q.Metric == MetricDevices {
+			uv = make([]*redis.IntCmd, len(buckets))
+			_, err = rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for i, bucket := range buckets {
+					uv[i] = p.PFCount(ctx, devicesKey(q.Aid, q.Granularity, bucket, f))
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for i, bucket := range buckets {
+			point := Point{Time: bucket}
+			if uv != nil {
+				point.Value = float64(uv[i].Val())
+			} else if s, ok := hashes[i].Val()[f]; ok {
+				point.Value, _ = strconv.ParseFloat(s, 64)
+			}
+			series[g].Points[i] = point
+		}
+	}
+
+	return series, nil
+}
+
+// Sum returns the count of an event over the buckets starting within [from, to).
+func Sum(ctx context.Context, rdb redis.Cmdable, cfg *Config, aid int, event string, granularity string, from time.Time, to time.Time) (float64, error) {
+	var cmds []*redis.StringCmd
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for t := truncate(from, granularity, cfg.Location); t.Before(to); t = next(t, granularity) {
+			if t.Before(from) {
+				continue
+			}
+			cmds = append(cmds, p.HGet(ctx, countKey(aid, granularity, t), field(event, "", "")))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	sum := 0.0
+	for _, cmd := range cmds {
+		if v, err := cmd.Float64(); err == nil {
+			sum += v
+		}
+	}
+
+	return sum, nil
+}
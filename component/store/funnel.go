@@ -0,0 +1,269 @@
+//
+// File: funnel.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oset/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MinFunnelSteps = 2
+	MaxFunnelSteps = 10
+
+	MaxFunnelWindow = 90 * 24 * time.Hour
+	MaxFunnelRange  = 93 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidFunnel = errors.New("invalid funnel")
+)
+
+// Step is a step of a funnel, an event with optional filters on its data.
+type Step struct {
+	Event string      `json:"event"`
+	Where []Predicate `json:"where"`
+}
+
+// Funnel counts the devices that did its steps in order, each within Window of
+// the first. Only devices whose first step falls within [From, To) are counted.
+type Funnel struct {
+	Aid    int
+	Steps  []Step
+	From   time.Time
+	To     time.Time
+	Window time.Duration
+}
+
+// StepResult is how many devices reached a step of a funnel. Conversion is
+// relative to the first step, StepConversion to the step before.
+type StepResult struct {
+	Step           int     `json:"step"`
+	Event          string  `json:"event"`
+	Devices        int     `json:"devices"`
+	DropOff        int     `json:"drop_off"`
+	Conversion     float64 `json:"conversion"`
+	StepConversion float64 `json:"step_conversion"`
+}
+
+// Check reports whether a funnel can be computed.
+func (f *Funnel) Check() error {
+	if f.Aid <= 0 {
+		return fmt.Errorf("%w: aid is required", ErrInvalidFunnel)
+	}
+
+	if len(f.Steps) < MinFunnelSteps || len(f.Steps) > MaxFunnelSteps {
+		return fmt.Errorf("%w: a funnel has %d to %d steps", ErrInvalidFunnel, MinFunnelSteps, MaxFunnelSteps)
+	}
+
+	if f.Window <= 0 || f.Window > MaxFunnelWindow {
+		return fmt.Errorf("%w: window must be within (0, %s]", ErrInvalidFunnel, MaxFunnelWindow)
+	}
+
+	if !f.From.Before(f.To) || f.To.Sub(f.From) > MaxFunnelRange {
+		return fmt.Errorf("%w: from must be before to and at most %s apart", ErrInvalidFunnel, MaxFunnelRange)
+	}
+
+	for i := range f.Steps {
+		if f.Steps[i].Event == "" {
+			return fmt.Errorf("%w: step %d has no event", ErrInvalidFunnel, i)
+		}
+
+		for j := range f.Steps[i].Where {
+			if _, _, err := f.Steps[i].Where[j].condition(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stepCondition is the sql condition an event matches a step under.
+func stepCondition(step *Step) (string, []interface{}, error) {
+	conds := []string{"event = ?"}
+	args := []interface{}{step.Event}
+	for i := range step.Where {
+		cond, cargs, err := step.Where[i].condition()
+		if err != nil {
+			return "", nil, err
+		}
+
+		conds = append(conds, cond)
+		args = append(args, cargs...)
+	}
+
+	return "(" + strings.Join(conds, " AND ") + ")", args, nil
+}
+
+// funnelDevice follows one device through a funnel. starts[k] is the latest
+// start of a run of the device that reached step k, the latest one leaves the
+// most time for the steps after it.
+type funnelDevice struct {
+	starts  []time.Time
+	reached []bool
+}
+
+func (d *funnelDevice) reset(steps int) {
+	if d.starts == nil {
+		d.starts = make([]time.Time, steps)
+		d.reached = make([]bool, steps)
+	}
+
+	for k := range d.starts {
+		d.starts[k] = time.Time{}
+		d.reached[k] = false
+	}
+}
+
+// add takes an event of the device in time order. An event is used for the
+// furthest step it moves a run to, so a run never takes one event twice.
+func (d *funnelDevice) add(f *Funnel, t time.Time, matches []bool) {
+	for k := len(matches) - 1; k >= 0; k-- {
+		if !matches[k] {
+			continue
+		}
+
+		if k == 0 {
+			if !t.Before(f.From) && t.Before(f.To) {
+				d.starts[0] = t
+				d.reached[0] = true
+			}
+			continue
+		}
+
+		if !d.reached[k-1] || t.Sub(d.starts[k-1]) > f.Window {
+			continue
+		}
+
+		if !d.reached[k] || d.starts[k-1].After(d.starts[k]) {
+			d.starts[k] = d.starts[k-1]
+			d.reached[k] = true
+		}
+	}
+}
+
+func (d *funnelDevice) depth() int {
+	for k := len(d.reached) - 1; k >= 0; k-- {
+		if d.reached[k] {
+			return k + 1
+		}
+	}
+
+	return 0
+}
+
+// RunFunnel computes a checked funnel from the events in the store.
+func RunFunnel(ctx context.Context, f *Funnel) ([]StepResult, error) {
+	t, err := currentTable()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		selects []string
+		conds   []string
+		args    []interface{}
+		cargs   []interface{}
+	)
+	for i := range f.Steps {
+		cond, a, err := stepCondition(&f.Steps[i])
+		if err != nil {
+			return nil, err
+		}
+
+		// a missing property makes the condition null rather than false
+		selects = append(selects, "COALESCE("+cond+", 0) AS s"+strconv.Itoa(i))
+		conds = append(conds, cond)
+		args = append(args, a...)
+		cargs = append(cargs, a...)
+	}
+
+	rows, err := db.Mysql().WithContext(ctx).Table(t).
+		Select("did, time, "+strings.Join(selects, ", "), args...).
+		Where("aid = ? AND did <> '' AND time >= ? AND time < ?", f.Aid, f.From, f.To.Add(f.Window)).
+		Where("("+strings.Join(conds, " OR ")+")", cargs...).
+		Order("did, time, id").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reached := make([]int, len(f.Steps))
+	count := func(d *funnelDevice) {
+		for k := 0; k < d.depth(); k++ {
+			reached[k]++
+		}
+	}
+
+	var (
+		device  funnelDevice
+		current string
+		started bool
+		did     string
+		at      time.Time
+	)
+	matches := make([]bool, len(f.Steps))
+	dest := []interface{}{&did, &at}
+	for i := range matches {
+		dest = append(dest, &matches[i])
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if !started || did != current {
+			if started {
+				count(&device)
+			}
+			device.reset(len(f.Steps))
+			current, started = did, true
+		}
+
+		device.add(f, at, matches)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if started {
+		count(&device)
+	}
+
+	results := make([]StepResult, len(f.Steps))
+	for k := range f.Steps {
+		results[k] = StepResult{
+			Step:    k,
+			Event:   f.Steps[k].Event,
+			Devices: reached[k],
+		}
+
+		if reached[0] > 0 {
+			results[k].Conversion = float64(reached[k]) / float64(reached[0])
+		}
+		if k > 0 {
+			results[k].DropOff = reached[k-1] - reached[k]
+			if reached[k-1] > 0 {
+				results[k].StepConversion = float64(reached[k]) / float64(reached[k-1])
+			}
+		} else if reached[0] > 0 {
+			results[k].StepConversion = 1
+		}
+	}
+
+	return results, nil
+}
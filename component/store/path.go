@@ -0,0 +1,310 @@
+//
+// File: path.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oset/db"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PathAfter  = "after"
+	PathBefore = "before"
+
+	MaxPathDepth = 10
+	MaxPathTopN  = 100
+	MaxPathRange = 31 * 24 * time.Hour
+
+	// events of a device past this many are not followed
+	maxDeviceEvents = 10000
+
+	pathSep = "\x1f"
+)
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+)
+
+// Path follows devices from every occurrence of Anchor within [From, To) to
+// the Depth events after it, or before it. A path ends early at a gap of more
+// than SessionGap between two events. Events in Exclude are skipped over.
+type Path struct {
+	Aid        int
+	Anchor     Step
+	Direction  string
+	Depth      int
+	TopN       int
+	SessionGap time.Duration
+	Exclude    []string
+	From       time.Time
+	To         time.Time
+}
+
+// Sequence is a path taken Count times by Devices devices, in time order.
+type Sequence struct {
+	Events  []string `json:"events"`
+	Count   int      `json:"count"`
+	Devices int      `json:"devices"`
+}
+
+// PathNode is an event at a position of the paths, the anchor being at 0 and
+// events before it at negative positions.
+type PathNode struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	Position int    `json:"position"`
+}
+
+// PathLink is how many of the top paths go from a node to the next.
+type PathLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Value  int    `json:"value"`
+}
+
+// PathResult is the top sequences of a path, and them as a sankey diagram.
+type PathResult struct {
+	Sequences []Sequence `json:"sequences"`
+	Nodes     []PathNode `json:"nodes"`
+	Links     []PathLink `json:"links"`
+}
+
+// Check reports whether a path can be computed.
+func (p *Path) Check() error {
+	if p.Aid <= 0 {
+		return fmt.Errorf("%w: aid is required", ErrInvalidPath)
+	}
+
+	if p.Anchor.Event == "" {
+		return fmt.Errorf("%w: anchor event is required", ErrInvalidPath)
+	}
+	if _, _, err := stepCondition(&p.Anchor); err != nil {
+		return err
+	}
+
+	if p.Direction != PathAfter && p.Direction != PathBefore {
+		return fmt.Errorf("%w: direction must be after or before", ErrInvalidPath)
+	}
+
+	if p.Depth <= 0 || p.Depth > MaxPathDepth {
+		return fmt.Errorf("%w: depth must be within [1, %d]", ErrInvalidPath, MaxPathDepth)
+	}
+
+	if p.TopN <= 0 || p.TopN > MaxPathTopN {
+		return fmt.Errorf("%w: top must be within [1, %d]", ErrInvalidPath, MaxPathTopN)
+	}
+
+	if p.SessionGap <= 0 {
+		return fmt.Errorf("%w: session gap must be positive", ErrInvalidPath)
+	}
+
+	if !p.From.Before(p.To) || p.To.Sub(p.From) > MaxPathRange {
+		return fmt.Errorf("%w: from must be before to and at most %s apart", ErrInvalidPath, MaxPathRange)
+	}
+
+	return nil
+}
+
+type pathEvent struct {
+	name   string
+	at     time.Time
+	anchor bool
+}
+
+type sequenceStat struct {
+	count   int
+	devices int
+	lastDid string
+}
+
+// follow returns the events of a path from the anchor at i, in time order.
+func (p *Path) follow(events []pathEvent, i int, exclude map[string]bool) []string {
+	step := 1
+	if p.Direction == PathBefore {
+		step = -1
+	}
+
+	seq := []string{events[i].name}
+	prev := events[i].at
+	for j := i + step; j >= 0 && j < len(events) && len(seq) <= p.Depth; j += step {
+		gap := events[j].at.Sub(prev)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap > p.SessionGap {
+			break
+		}
+		prev = events[j].at
+
+		if exclude[events[j].name] {
+			continue
+		}
+		seq = append(seq, events[j].name)
+	}
+
+	if p.Direction == PathBefore {
+		for l, r := 0, len(seq)-1; l < r; l, r = l+1, r-1 {
+			seq[l], seq[r] = seq[r], seq[l]
+		}
+	}
+
+	return seq
+}
+
+// RunPath computes the top sequences of a checked path from the events in
+// the store.
+func RunPath(ctx context.Context, p *Path) (*PathResult, error) {
+	t, err := currentTable()
+	if err != nil {
+		return nil, err
+	}
+
+	cond, args, err := stepCondition(&p.Anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	// a path spans at most depth gaps on either side of its anchor
+	span := time.Duration(p.Depth) * p.SessionGap
+	rows, err := db.Mysql().WithContext(ctx).Table(t).
+		Select("did, time, event, COALESCE("+cond+", 0)", args...).
+		Where("aid = ? AND did <> '' AND time >= ? AND time < ?", p.Aid, p.From.Add(-span), p.To.Add(span)).
+		Order("did, time, id").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exclude := make(map[string]bool, len(p.Exclude))
+	for _, name := range p.Exclude {
+		exclude[name] = true
+	}
+
+	stats := make(map[string]*sequenceStat)
+	flush := func(did string, events []pathEvent) {
+		for i := range events {
+			if !events[i].anchor || events[i].at.Before(p.From) || !events[i].at.Before(p.To) {
+				continue
+			}
+
+			key := strings.Join(p.follow(events, i, exclude), pathSep)
+			s := stats[key]
+			if s == nil {
+				s = &sequenceStat{}
+				stats[key] = s
+			}
+
+			s.count++
+			if s.lastDid != did || s.devices == 0 {
+				s.devices++
+				s.lastDid = did
+			}
+		}
+	}
+
+	var (
+		current string
+		events  []pathEvent
+		did     string
+		ev      pathEvent
+	)
+	for rows.Next() {
+		if err = rows.Scan(&did, &ev.at, &ev.name, &ev.anchor); err != nil {
+			return nil, err
+		}
+
+		if did != current {
+			flush(current, events)
+			current, events = did, events[:0]
+		}
+
+		if len(events) < maxDeviceEvents {
+			events = append(events, ev)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	flush(current, events)
+
+	return p.top(stats), nil
+}
+
+// top returns the TopN most taken sequences and their sankey diagram.
+func (p *Path) top(stats map[string]*sequenceStat) *PathResult {
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if stats[keys[i]].count != stats[keys[j]].count {
+			return stats[keys[i]].count > stats[keys[j]].count
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > p.TopN {
+		keys = keys[:p.TopN]
+	}
+
+	res := &PathResult{
+		Sequences: make([]Sequence, 0, len(keys)),
+	}
+
+	nodes := make(map[string]bool)
+	links := make(map[[2]string]int)
+	var order [][2]string
+	for _, key := range keys {
+		events := strings.Split(key, pathSep)
+		res.Sequences = append(res.Sequences, Sequence{
+			Events:  events,
+			Count:   stats[key].count,
+			Devices: stats[key].devices,
+		})
+
+		// the anchor is the first event after it and the last before it
+		offset := 0
+		if p.Direction == PathBefore {
+			offset = len(events) - 1
+		}
+
+		var prev string
+		for i, name := range events {
+			pos := i - offset
+			id := strconv.Itoa(pos) + ":" + name
+			if !nodes[id] {
+				nodes[id] = true
+				res.Nodes = append(res.Nodes, PathNode{ID: id, Event: name, Position: pos})
+			}
+
+			if i > 0 {
+				link := [2]string{prev, id}
+				if _, ok := links[link]; !ok {
+					order = append(order, link)
+				}
+				links[link] += stats[key].count
+			}
+			prev = id
+		}
+	}
+
+	for _, link := range order {
+		res.Links = append(res.Links, PathLink{Source: link[0], Target: link[1], Value: links[link]})
+	}
+
+	return res
+}
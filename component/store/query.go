@@ -0,0 +1,293 @@
+//
+// File: query.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oset/db"
+	"oset/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpGt     = "gt"
+	OpGte    = "gte"
+	OpLt     = "lt"
+	OpLte    = "lte"
+	OpIn     = "in"
+	OpExists = "exists"
+)
+
+var (
+	ErrInvalidQuery     = errors.New("invalid event query")
+	ErrInvalidPredicate = errors.New("invalid property predicate")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+)
+
+var comparisons = map[string]string{
+	OpEq:  "=",
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+}
+
+// Predicate filters events on a property of their data. Property is a dot
+// path into the data, Value is compared as json, so 1 and "1" differ.
+type Predicate struct {
+	Property string      `json:"property"`
+	Op       string      `json:"op"`
+	Value    interface{} `json:"value"`
+}
+
+// Query selects the events of an app. From is inclusive and To exclusive,
+// zero times leave the range open. The events of a page come in Order of
+// their time, Cursor is the Next of the page before.
+type Query struct {
+	Aid    int         `json:"aid"`
+	Did    string      `json:"did"`
+	UserID string      `json:"user_id"`
+	Events []string    `json:"events"`
+	From   time.Time   `json:"from"`
+	To     time.Time   `json:"to"`
+	Where  []Predicate `json:"where"`
+	Order  string      `json:"order"`
+	Limit  int         `json:"limit"`
+	Cursor string      `json:"cursor"`
+}
+
+// Page is a page of query results. Next is empty on the last page.
+type Page struct {
+	Events []model.Event `json:"events"`
+	Next   string        `json:"next"`
+}
+
+// cursor is the position of the last event of a page.
+type cursor struct {
+	time time.Time
+	id   int64
+}
+
+func (c cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.time.UnixMilli(), 10) + ":" + strconv.FormatInt(c.id, 10)))
+}
+
+func parseCursor(s string) (c cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	sms, sid, ok := strings.Cut(string(b), ":")
+	ms, merr := strconv.ParseInt(sms, 10, 64)
+	id, ierr := strconv.ParseInt(sid, 10, 64)
+	if !ok || merr != nil || ierr != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return cursor{time: time.UnixMilli(ms), id: id}, nil
+}
+
+// jsonPath turns a dot path into a mysql json path, quoting each member.
+func jsonPath(property string) (string, error) {
+	if property == "" || strings.ContainsAny(property, "\"\\") {
+		return "", fmt.Errorf("%w: property %q", ErrInvalidPredicate, property)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, member := range strings.Split(property, ".") {
+		if member == "" {
+			return "", fmt.Errorf("%w: property %q", ErrInvalidPredicate, property)
+		}
+		sb.WriteString(`."` + member + `"`)
+	}
+
+	return sb.String(), nil
+}
+
+func jsonValue(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPredicate, err.Error())
+	}
+
+	return string(b), nil
+}
+
+// condition returns the sql condition of a predicate and its arguments.
+func (p *Predicate) condition() (string, []interface{}, error) {
+	path, err := jsonPath(p.Property)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch p.Op {
+	case OpExists:
+		return "JSON_CONTAINS_PATH(data, 'one', ?)", []interface{}{path}, nil
+	case OpNe:
+		value, err := jsonValue(p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (JSON_EXTRACT(data, ?) <=> CAST(? AS JSON))", []interface{}{path, value}, nil
+	case OpIn:
+		// mysql does not compare json values with IN
+		values, ok := p.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return "", nil, fmt.Errorf("%w: in needs a list of values", ErrInvalidPredicate)
+		}
+
+		conds := make([]string, 0, len(values))
+		args := make([]interface{}, 0, len(values)*2)
+		for _, v := range values {
+			value, err := jsonValue(v)
+			if err != nil {
+				return "", nil, err
+			}
+
+			conds = append(conds, "JSON_EXTRACT(data, ?) = CAST(? AS JSON)")
+			args = append(args, path, value)
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", args, nil
+	}
+
+	cmp, ok := comparisons[p.Op]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPredicate, p.Op)
+	}
+
+	value, err := jsonValue(p.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return "JSON_EXTRACT(data, ?) " + cmp + " CAST(? AS JSON)", []interface{}{path, value}, nil
+}
+
+// Check reports whether a query can be run, filling in its defaults.
+func (q *Query) Check() error {
+	if q.Aid <= 0 {
+		return fmt.Errorf("%w: aid is required", ErrInvalidQuery)
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+
+	switch q.Order {
+	case "":
+		q.Order = OrderDesc
+	case OrderAsc, OrderDesc:
+	default:
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidQuery)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	} else if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+
+	for i := range q.Where {
+		if _, _, err := q.Where[i].condition(); err != nil {
+			return err
+		}
+	}
+
+	if q.Cursor != "" {
+		if _, err := parseCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run returns a page of the events matching a checked query.
+func Run(q *Query) (page Page, err error) {
+	t, err := currentTable()
+	if err != nil {
+		return
+	}
+
+	tx := db.Mysql().Table(t).Where("aid = ?", q.Aid)
+	if q.Did != "" {
+		tx = tx.Where("did = ?", q.Did)
+	}
+	if q.UserID != "" {
+		tx = tx.Where("user_id = ?", q.UserID)
+	}
+	if len(q.Events) > 0 {
+		tx = tx.Where("event IN ?", q.Events)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("time >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("time < ?", q.To)
+	}
+
+	for i := range q.Where {
+		cond, args, err := q.Where[i].condition()
+		if err != nil {
+			return page, err
+		}
+		tx = tx.Where(cond, args...)
+	}
+
+	cmp := "<"
+	if q.Order == OrderAsc {
+		cmp = ">"
+	}
+
+	if q.Cursor != "" {
+		c, err := parseCursor(q.Cursor)
+		if err != nil {
+			return page, err
+		}
+		tx = tx.Where("(time "+cmp+" ? OR (time = ? AND id "+cmp+" ?))", c.time, c.time, c.id)
+	}
+
+	var rows []model.StoredEvent
+	err = tx.Order("time " + q.Order + ", id " + q.Order).Limit(q.Limit + 1).Find(&rows).Error
+	if err != nil {
+		return
+	}
+
+	if len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+		last := rows[len(rows)-1]
+		page.Next = cursor{time: last.Time, id: last.ID}.String()
+	}
+
+	page.Events = make([]model.Event, 0, len(rows))
+	for i := range rows {
+		var event model.Event
+		if err = json.Unmarshal([]byte(rows[i].Payload), &event); err != nil {
+			return page, fmt.Errorf("decode stored event %d: %w", rows[i].ID, err)
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	return page, nil
+}
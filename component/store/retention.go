@@ -0,0 +1,402 @@
+//
+// File: retention.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package store
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"oset/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	RetentionDay  = "day"
+	RetentionWeek = "week"
+
+	// RetentionBounded counts devices that came back in a period,
+	// RetentionUnbounded those that came back in it or any period after.
+	RetentionBounded   = "bounded"
+	RetentionUnbounded = "unbounded"
+
+	MaxRetentionPeriods = 60
+	MaxRetentionCohorts = 92
+
+	retentionCacheTTL = 7 * 24 * time.Hour
+
+	// versions of a day live as long as any cohort reading it may be cached
+	versionTTL = 400 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRetention = errors.New("invalid retention")
+)
+
+// Retention follows cohorts of devices that did Start in a period between
+// From and To, counting those that did Return in each of the Periods after.
+// A device may belong to several cohorts.
+type Retention struct {
+	Aid         int
+	Start       Step
+	Return      Step
+	Granularity string
+	Mode        string
+	From        time.Time
+	To          time.Time
+	Periods     int
+	Location    *time.Location
+}
+
+// Cohort is a row of a retention table. Retained[n] is how many of its devices
+// came back n periods after the cohort, period 0 being the cohort itself.
+// Periods that have not started yet are left out.
+type Cohort struct {
+	Start    time.Time `json:"start"`
+	Devices  int       `json:"devices"`
+	Retained []int     `json:"retained"`
+	Rates    []float64 `json:"rates"`
+}
+
+// Check reports whether a retention table can be computed.
+func (r *Retention) Check() error {
+	if r.Aid <= 0 {
+		return fmt.Errorf("%w: aid is required", ErrInvalidRetention)
+	}
+
+	if r.Start.Event == "" || r.Return.Event == "" {
+		return fmt.Errorf("%w: start and return events are required", ErrInvalidRetention)
+	}
+
+	for _, step := range []*Step{&r.Start, &r.Return} {
+		if _, _, err := stepCondition(step); err != nil {
+			return err
+		}
+	}
+
+	if r.Granularity != RetentionDay && r.Granularity != RetentionWeek {
+		return fmt.Errorf("%w: granularity must be day or week", ErrInvalidRetention)
+	}
+
+	if r.Mode != RetentionBounded && r.Mode != RetentionUnbounded {
+		return fmt.Errorf("%w: mode must be bounded or unbounded", ErrInvalidRetention)
+	}
+
+	if r.Periods <= 0 || r.Periods > MaxRetentionPeriods {
+		return fmt.Errorf("%w: periods must be within [1, %d]", ErrInvalidRetention, MaxRetentionPeriods)
+	}
+
+	if !r.From.Before(r.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidRetention)
+	}
+
+	if len(r.cohorts()) > MaxRetentionCohorts {
+		return fmt.Errorf("%w: at most %d cohorts", ErrInvalidRetention, MaxRetentionCohorts)
+	}
+
+	return nil
+}
+
+// period returns the start of the period t falls in, weeks start on monday.
+func (r *Retention) period(t time.Time) time.Time {
+	t = t.In(r.Location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.Location)
+	if r.Granularity == RetentionWeek {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+
+	return day
+}
+
+func (r *Retention) next(p time.Time, n int) time.Time {
+	if r.Granularity == RetentionWeek {
+		return p.AddDate(0, 0, 7*n)
+	}
+
+	return p.AddDate(0, 0, n)
+}
+
+// cohorts returns the start of every cohort period of the table.
+func (r *Retention) cohorts() []time.Time {
+	var starts []time.Time
+	for p := r.period(r.From); p.Before(r.To); p = r.next(p, 1) {
+		starts = append(starts, p)
+		if len(starts) > MaxRetentionCohorts {
+			break
+		}
+	}
+
+	return starts
+}
+
+// index returns which period after first t falls in.
+func (r *Retention) index(first time.Time, t time.Time) int {
+	// rounded, days around a daylight saving change are not 24 hours long
+	days := int(math.Round(r.period(t).Sub(first).Hours() / 24))
+
+	if r.Granularity == RetentionWeek {
+		return days / 7
+	}
+	return days
+}
+
+// compute counts the cohorts, which must be consecutive, in one pass over the
+// events they cover.
+func (r *Retention) compute(ctx context.Context, t string, cohorts []time.Time, now time.Time) ([]Cohort, error) {
+	first := cohorts[0]
+	cohortsEnd := r.next(cohorts[len(cohorts)-1], 1)
+	returnsEnd := r.next(cohortsEnd, r.Periods)
+
+	startCond, startArgs, err := stepCondition(&r.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cohorts of each device, by their index from the first
+	members := make(map[string][]int)
+	err = scanDeviceTimes(ctx, t, r.Aid, first, cohortsEnd, startCond, startArgs, func(did string, at time.Time) {
+		c := r.index(first, at)
+		if cs := members[did]; len(cs) == 0 || cs[len(cs)-1] != c {
+			members[did] = append(cs, c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	returnCond, returnArgs, err := stepCondition(&r.Return)
+	if err != nil {
+		return nil, err
+	}
+
+	// the periods each member came back in, by their index from the first cohort
+	returns := make(map[string]map[int]bool)
+	err = scanDeviceTimes(ctx, t, r.Aid, first, returnsEnd, returnCond, returnArgs, func(did string, at time.Time) {
+		if _, ok := members[did]; !ok {
+			return
+		}
+
+		if returns[did] == nil {
+			returns[did] = make(map[int]bool)
+		}
+		returns[did][r.index(first, at)] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Cohort, len(cohorts))
+	for c, start := range cohorts {
+		periods := r.elapsed(start, now)
+		results[c] = Cohort{
+			Start:    start,
+			Retained: make([]int, periods),
+			Rates:    make([]float64, periods),
+		}
+	}
+
+	for did, cs := range members {
+		for _, c := range cs {
+			results[c].Devices++
+
+			last := -1
+			for n := range results[c].Retained {
+				if returns[did][c+n] {
+					last = n
+					if r.Mode == RetentionBounded {
+						results[c].Retained[n]++
+					}
+				}
+			}
+
+			if r.Mode == RetentionUnbounded {
+				for n := 0; n <= last; n++ {
+					results[c].Retained[n]++
+				}
+			}
+		}
+	}
+
+	for c := range results {
+		if results[c].Devices == 0 {
+			continue
+		}
+
+		for n, retained := range results[c].Retained {
+			results[c].Rates[n] = float64(retained) / float64(results[c].Devices)
+		}
+	}
+
+	return results, nil
+}
+
+// scanDeviceTimes calls fn with the device and time of every event of an app
+// in [from, to) that matches cond, in order of the device.
+func scanDeviceTimes(ctx context.Context, t string, aid int, from time.Time, to time.Time, cond string, args []interface{}, fn func(did string, at time.Time)) error {
+	rows, err := db.Mysql().WithContext(ctx).Table(t).
+		Select("did, time").
+		Where("aid = ? AND did <> '' AND time >= ? AND time < ?", aid, from, to).
+		Where(cond, args...).
+		Order("did, time").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		did string
+		at  time.Time
+	)
+	for rows.Next() {
+		if err = rows.Scan(&did, &at); err != nil {
+			return err
+		}
+		fn(did, at)
+	}
+
+	return rows.Err()
+}
+
+func versionKey(aid int, day time.Time) string {
+	return "retention:ver:" + strconv.Itoa(aid) + ":" + day.UTC().Format(partitionLayout)
+}
+
+// touchDays bumps the versions of the days that got new events, so that the
+// cached cohorts reading them are computed again.
+func touchDays(ctx context.Context, rdb redis.Cmdable, keys map[string]bool) error {
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for key := range keys {
+			p.Incr(ctx, key)
+			p.Expire(ctx, key, versionTTL)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// key identifies the table a cohort is cached for, the range aside.
+func (r *Retention) key() string {
+	b, _ := json.Marshal([]interface{}{r.Start, r.Return, r.Granularity, r.Mode, r.Periods, r.Location.String()})
+	sum := sha1.Sum(b)
+	return "retention:" + strconv.Itoa(r.Aid) + ":" + hex.EncodeToString(sum[:])
+}
+
+// fingerprint returns the versions of the days a cohort reads.
+func (r *Retention) fingerprint(ctx context.Context, rdb redis.Cmdable, start time.Time) (string, error) {
+	end := r.next(start, r.Periods+1)
+
+	var keys []string
+	for day := start.UTC().Truncate(24 * time.Hour); day.Before(end); day = day.Add(24 * time.Hour) {
+		keys = append(keys, versionKey(r.Aid, day))
+	}
+
+	versions, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, len(versions))
+	for i, v := range versions {
+		if s, ok := v.(string); ok {
+			parts[i] = s
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+type cachedCohort struct {
+	Fingerprint string `json:"fingerprint"`
+	Cohort      Cohort `json:"cohort"`
+}
+
+// RunRetention returns the table of a checked retention. Cohorts are cached in
+// redis until events fall into a day they read or one of their periods starts,
+// only the cohorts that are not cached are computed.
+func RunRetention(ctx context.Context, rdb redis.Cmdable, r *Retention) ([]Cohort, error) {
+	t, err := currentTable()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	starts := r.cohorts()
+	results := make([]Cohort, len(starts))
+	prints := make([]string, len(starts))
+	unversioned := make(map[int]bool)
+	missing := []int{}
+
+	prefix := r.key()
+	for i, start := range starts {
+		prints[i], err = r.fingerprint(ctx, rdb, start)
+		if err != nil {
+			etlog.L().Warn("failed to read retention versions", zap.Int("aid", r.Aid), zap.Error(err))
+			unversioned[i] = true
+			missing = append(missing, i)
+			continue
+		}
+
+		var cached cachedCohort
+		b, err := rdb.Get(ctx, prefix+":"+strconv.FormatInt(start.Unix(), 10)).Bytes()
+		if err != nil || json.Unmarshal(b, &cached) != nil || cached.Fingerprint != prints[i] ||
+			len(cached.Cohort.Retained) != r.elapsed(start, now) {
+			missing = append(missing, i)
+			continue
+		}
+
+		results[i] = cached.Cohort
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	// the missing cohorts are computed in one pass from the first to the last
+	lo, hi := missing[0], missing[len(missing)-1]
+	computed, err := r.compute(ctx, t, starts[lo:hi+1], now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range missing {
+		results[i] = computed[i-lo]
+		if unversioned[i] {
+			// what can not be invalidated is not cached
+			continue
+		}
+
+		b, _ := json.Marshal(cachedCohort{Fingerprint: prints[i], Cohort: results[i]})
+		if err := rdb.Set(ctx, prefix+":"+strconv.FormatInt(starts[i].Unix(), 10), b, retentionCacheTTL).Err(); err != nil {
+			etlog.L().Warn("failed to cache retention cohort", zap.Int("aid", r.Aid), zap.Error(err))
+		}
+	}
+
+	return results, nil
+}
+
+// elapsed returns how many periods of a cohort have started by now.
+func (r *Retention) elapsed(start time.Time, now time.Time) int {
+	periods := 0
+	for n := 0; n <= r.Periods && !r.next(start, n).After(now); n++ {
+		periods++
+	}
+
+	return periods
+}
@@ -0,0 +1,249 @@
+//
+// File: store.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+// Package store keeps events in a mysql table partitioned by day, so that they
+// can be queried by the proxy itself. Partitions past the retention are dropped
+// as a whole.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oset/db"
+	"oset/model"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dizzrt/etlog"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultTable     = "event_store"
+	DefaultRetention = 30
+
+	batchSize = 500
+
+	// partitions are made for this many days ahead
+	partitionsAhead     = 3
+	maintenanceInterval = time.Hour
+
+	futurePartition = "p_future"
+	partitionLayout = "20060102"
+)
+
+var (
+	ErrDisabled     = errors.New("event store is not enabled")
+	ErrInvalidTable = errors.New("invalid event store table name")
+)
+
+var tablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+var (
+	mu        sync.RWMutex
+	table     string
+	retention int
+	stop      chan struct{}
+)
+
+const createTableSQL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"`id` BIGINT NOT NULL AUTO_INCREMENT, " +
+	"`aid` INT NOT NULL, " +
+	"`did` VARCHAR(128) NOT NULL DEFAULT '', " +
+	"`user_id` VARCHAR(128) NOT NULL DEFAULT '', " +
+	"`event` VARCHAR(128) NOT NULL, " +
+	"`time` DATETIME(3) NOT NULL, " +
+	"`data` JSON, " +
+	"`payload` MEDIUMTEXT, " +
+	"PRIMARY KEY (`id`, `time`), " +
+	"KEY `idx_%[1]s_aid_time` (`aid`, `time`), " +
+	"KEY `idx_%[1]s_aid_did_time` (`aid`, `did`, `time`), " +
+	"KEY `idx_%[1]s_aid_user_time` (`aid`, `user_id`, `time`), " +
+	"KEY `idx_%[1]s_aid_event_time` (`aid`, `event`, `time`)" +
+	") PARTITION BY RANGE (TO_DAYS(`time`)) (PARTITION `" + futurePartition + "` VALUES LESS THAN MAXVALUE)"
+
+// Open creates the store table when it does not exist and starts keeping its
+// partitions. Events older than retentionDays are dropped, 0 keeps them.
+func Open(name string, retentionDays int) error {
+	if !tablePattern.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidTable, name)
+	}
+
+	if err := db.Mysql().Exec(fmt.Sprintf(createTableSQL, name)).Error; err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	table = name
+	retention = retentionDays
+	if stop == nil {
+		stop = make(chan struct{})
+		go runMaintenance(stop)
+	}
+
+	return nil
+}
+
+// Close stops keeping the partitions. The table is left as is.
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+	table = ""
+}
+
+func currentTable() (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if table == "" {
+		return "", ErrDisabled
+	}
+	return table, nil
+}
+
+// Write inserts events into the store.
+func Write(events []model.Event) error {
+	t, err := currentTable()
+	if err != nil {
+		return err
+	}
+
+	rows := make([]model.StoredEvent, 0, len(events))
+	for i := range events {
+		payload, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+
+		data := events[i].Data
+		if data == "" {
+			data = "{}"
+		}
+
+		rows = append(rows, model.StoredEvent{
+			Aid:     events[i].Aid,
+			Did:     string(events[i].Did),
+			UserID:  events[i].UserID,
+			Event:   events[i].Event,
+			Time:    events[i].Time,
+			Data:    data,
+			Payload: string(payload),
+		})
+	}
+
+	err = db.Mysql().Table(t).CreateInBatches(rows, batchSize).Error
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[string]bool)
+	for i := range rows {
+		touched[versionKey(rows[i].Aid, rows[i].Time)] = true
+	}
+
+	if err = touchDays(context.Background(), db.Redis(), touched); err != nil {
+		// cached analyses of these days may be served stale until they expire
+		etlog.L().Warn("failed to bump event store versions", zap.Error(err))
+	}
+
+	return nil
+}
+
+func runMaintenance(stop chan struct{}) {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		maintain()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maintain adds the partitions of the next days and drops those past the
+// retention. Instances racing on it fail harmlessly, the next run catches up.
+func maintain() {
+	mu.RLock()
+	t, keep := table, retention
+	mu.RUnlock()
+	if t == "" {
+		return
+	}
+
+	var names []string
+	err := db.Mysql().Raw("SELECT PARTITION_NAME FROM INFORMATION_SCHEMA.PARTITIONS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL", t).
+		Scan(&names).Error
+	if err != nil {
+		etlog.L().Error("failed to list event store partitions", zap.String("table", t), zap.Error(err))
+		return
+	}
+
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var adds []string
+	for i := 0; i <= partitionsAhead; i++ {
+		day := today.AddDate(0, 0, i)
+		name := "p" + day.Format(partitionLayout)
+		if existing[name] {
+			continue
+		}
+
+		adds = append(adds, fmt.Sprintf("PARTITION `%s` VALUES LESS THAN (TO_DAYS('%s'))", name, day.AddDate(0, 0, 1).Format("2006-01-02")))
+	}
+
+	if len(adds) > 0 {
+		adds = append(adds, "PARTITION `"+futurePartition+"` VALUES LESS THAN MAXVALUE")
+		sql := fmt.Sprintf("ALTER TABLE `%s` REORGANIZE PARTITION `%s` INTO (%s)", t, futurePartition, strings.Join(adds, ", "))
+		if err = db.Mysql().Exec(sql).Error; err != nil {
+			etlog.L().Warn("failed to add event store partitions", zap.String("table", t), zap.Error(err))
+		}
+	}
+
+	if keep <= 0 {
+		return
+	}
+
+	oldest := "p" + today.AddDate(0, 0, -keep).Format(partitionLayout)
+	var drops []string
+	for _, name := range names {
+		if name != futurePartition && name < oldest {
+			drops = append(drops, "`"+name+"`")
+		}
+	}
+
+	if len(drops) > 0 {
+		sql := fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", t, strings.Join(drops, ", "))
+		if err = db.Mysql().Exec(sql).Error; err != nil {
+			etlog.L().Warn("failed to drop expired event store partitions", zap.String("table", t), zap.Error(err))
+			return
+		}
+
+		etlog.L().Info("dropped expired event store partitions", zap.String("table", t), zap.Strings("partitions", drops))
+	}
+}
@@ -0,0 +1,112 @@
+//
+// File: useragent.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package useragent
+
+import (
+	"oset/model"
+	"regexp"
+	"strings"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+type rule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// rules are tried in order, so more specific products come first: Edge and
+// Opera also claim to be Chrome, and Chrome claims to be Safari.
+var (
+	osRules = []rule{
+		{"Windows Phone", regexp.MustCompile(`Windows Phone(?: OS)? ([\d.]+)`)},
+		{"Windows", regexp.MustCompile(`Windows NT ([\d.]+)`)},
+		{"iPadOS", regexp.MustCompile(`iPad.*? OS ([\d_]+)`)},
+		{"iOS", regexp.MustCompile(`(?:iPhone|iPod).*? OS ([\d_]+)`)},
+		{"HarmonyOS", regexp.MustCompile(`HarmonyOS[ /]?([\d.]*)`)},
+		{"Android", regexp.MustCompile(`Android ?([\d.]*)`)},
+		{"Chrome OS", regexp.MustCompile(`CrOS \S+ ([\d.]+)`)},
+		{"macOS", regexp.MustCompile(`Mac OS X ?([\d_.]*)`)},
+		{"Linux", regexp.MustCompile(`Linux()`)},
+	}
+
+	browserRules = []rule{
+		{"WeChat", regexp.MustCompile(`MicroMessenger/([\d.]+)`)},
+		{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+		{"UC Browser", regexp.MustCompile(`UCBrowser/([\d.]+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+		{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+		{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+	}
+
+	botPattern    = regexp.MustCompile(`(?i)bot|crawler|spider|slurp|curl/|wget/|python-requests|go-http-client|okhttp`)
+	mobilePattern = regexp.MustCompile(`(?i)mobi|iphone|ipod|android|windows phone|blackberry|opera mini`)
+)
+
+func match(rules []rule, ua string) (name string, version string) {
+	for _, r := range rules {
+		if m := r.re.FindStringSubmatch(ua); m != nil {
+			version = ""
+			if len(m) > 1 {
+				version = strings.ReplaceAll(m[1], "_", ".")
+			}
+			return r.name, version
+		}
+	}
+
+	return "", ""
+}
+
+// Parse extracts the operating system, browser and device type from a
+// User-Agent header. Unknown parts are left empty.
+func Parse(ua string) (info model.UserAgentInfo) {
+	if ua == "" {
+		return
+	}
+
+	info.OS, info.OSVersion = match(osRules, ua)
+	info.Browser, info.BrowserVersion = match(browserRules, ua)
+	info.DeviceType = deviceType(ua)
+	return
+}
+
+func deviceType(ua string) string {
+	switch {
+	case botPattern.MatchString(ua):
+		return DeviceBot
+	case isTablet(ua):
+		return DeviceTablet
+	case mobilePattern.MatchString(ua):
+		return DeviceMobile
+	case strings.Contains(ua, "Windows") || strings.Contains(ua, "Macintosh") || strings.Contains(ua, "X11") || strings.Contains(ua, "CrOS"):
+		return DeviceDesktop
+	}
+
+	return DeviceUnknown
+}
+
+func isTablet(ua string) bool {
+	lower := strings.ToLower(ua)
+	if strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet") || strings.Contains(lower, "kindle") || strings.Contains(lower, "silk/") {
+		return true
+	}
+
+	// android tablets leave "Mobile" out of their user agent
+	return strings.Contains(lower, "android") && !strings.Contains(lower, "mobile")
+}
@@ -66,6 +66,51 @@ func AutoMigrate() {
 	if err != nil {
 		etlog.L().Panic("failed to migrate aksk table", zap.Error(err))
 	}
+
+	err = mysqlDB.AutoMigrate(&model.EventSchema{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate event schema table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.DeadLetter{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate dead letter table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.AppLimit{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate app limit table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.SamplingRule{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate sampling rule table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.RedactionRule{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate redaction rule table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.Identity{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate identity table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.Profile{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate profile table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.AlertRule{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate alert rule table", zap.Error(err))
+	}
+
+	err = mysqlDB.AutoMigrate(&model.AlertEvent{})
+	if err != nil {
+		etlog.L().Panic("failed to migrate alert event table", zap.Error(err))
+	}
 }
 
 func Mysql() *gorm.DB {
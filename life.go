@@ -32,14 +32,15 @@ func init() {
 	}
 
 	log.InitLog()
-	controller.InitEvent()
 	db.InitMysqlFromViper()
 	db.InitRedisFromViper()
+	controller.InitEvent()
 }
 
 func Defer() {
 	fmt.Printf("\nstopping oset...\n")
 
+	controller.CloseEvent()
 	etlog.L().Sync()
 	viper.WriteConfig()
 	time.Sleep(time.Second)
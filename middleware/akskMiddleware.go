@@ -23,10 +23,17 @@
 	headerSignature = `x-auth-signature`
 	headerTimestamp = `x-auth-timestamp`
 	headerContent   = `x-auth-content`
+
+	// logged in place of report bodies until a handler has redacted them
+	withheldBody = "[withheld]"
 )
 
 func AkskMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		// reports may carry personal data, the handler sets a redacted body
+		// once it knows the app, any request rejected before is logged without
+		ctx.Set("log_body", withheldBody)
+
 		accesskey := ctx.GetHeader(headerAccessKey)
 		signature := ctx.GetHeader(headerSignature)
 		timestamp := ctx.GetHeader(headerTimestamp)
@@ -57,6 +64,8 @@ func AkskMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		ctx.Set("ak", accesskey)
+		ctx.Set("signed_at", t)
 		ctx.Next()
 	}
 }
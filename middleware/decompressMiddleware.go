@@ -0,0 +1,43 @@
+//
+// File: decompressMiddleware.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"oset/common/stream"
+
+	"github.com/Dizzrt/etlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// DecompressMiddleware accepts gzip, deflate and zstd encoded request bodies
+// and refuses bodies over the size limit. It runs after authentication, so
+// that only the bodies of known clients are decompressed.
+func DecompressMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := stream.DecodeBody(ctx)
+		if err != nil {
+			etlog.L().Warn("failed to decompress request body", zap.String("path", ctx.Request.URL.Path), zap.String("encoding", ctx.GetHeader("Content-Encoding")), zap.Error(err))
+
+			if errors.Is(err, stream.ErrBodyTooLarge) {
+				abortCtx(ctx, http.StatusRequestEntityTooLarge, err.Error())
+			} else if errors.Is(err, stream.ErrUnsupportedEncoding) {
+				abortCtx(ctx, http.StatusUnsupportedMediaType, err.Error())
+			} else {
+				abortCtx(ctx, http.StatusBadRequest, "decompress body failed: "+err.Error())
+			}
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -34,6 +34,12 @@ func GinLogger() gin.HandlerFunc {
 		start := time.Now()
 		ctx.Next()
 		elapsed := time.Since(start).Milliseconds()
+
+		// handlers of bodies that may carry personal data leave a redacted copy
+		if redacted, ok := ctx.Get("log_body"); ok {
+			body = redacted.(string)
+		}
+
 		zap.L().Info(path,
 			zap.Int("status", ctx.Writer.Status()),
 			zap.String("method", ctx.Request.Method),
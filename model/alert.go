@@ -0,0 +1,68 @@
+//
+// File: alert.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	ALERT_KIND_THRESHOLD = "threshold"
+	ALERT_KIND_BASELINE  = "baseline"
+
+	ALERT_DIRECTION_ABOVE = "above"
+	ALERT_DIRECTION_BELOW = "below"
+
+	ALERT_STATE_OK     = "ok"
+	ALERT_STATE_FIRING = "firing"
+)
+
+// AlertRule watches the count of Event, $all for every reported event, over
+// the last Window seconds. A threshold rule fires when the count goes past
+// Threshold in Direction. A baseline rule fires when it deviates by more than
+// Deviation, a fraction, from the count of the same span in the BaselineWeeks
+// weeks before, averaged; baselines under MinBaseline are too small to tell and
+// leave the state as is.
+type AlertRule struct {
+	ID            int     `gorm:"primaryKey" json:"id" form:"id"`
+	Aid           int     `gorm:"index;not null" json:"aid" form:"aid"`
+	Name          string  `gorm:"size:128;not null" json:"name" form:"name"`
+	Event         string  `gorm:"size:128;not null" json:"event" form:"event"`
+	Window        int     `gorm:"not null" json:"window" form:"window"`
+	Kind          string  `gorm:"size:16;not null" json:"kind" form:"kind"`
+	Direction     string  `gorm:"size:16;not null" json:"direction" form:"direction"`
+	Threshold     float64 `json:"threshold" form:"threshold"`
+	Deviation     float64 `json:"deviation" form:"deviation"`
+	BaselineWeeks int     `json:"baseline_weeks" form:"baseline_weeks"`
+	MinBaseline   float64 `json:"min_baseline" form:"min_baseline"`
+	WebhookURL    string  `gorm:"size:512;not null" json:"webhook_url" form:"webhook_url"`
+	Enabled       bool    `gorm:"bool;default:true" json:"enabled" form:"enabled"`
+	Description   string  `gorm:"size:255" json:"des" form:"des"`
+
+	// State is kept by the alert scheduler
+	State       string  `gorm:"size:16;default:ok" json:"state" form:"-"`
+	LastValue   float64 `json:"last_value" form:"-"`
+	EvaluatedAt int64   `json:"evaluated_at" form:"-"`
+	ChangedAt   int64   `json:"changed_at" form:"-"`
+
+	CreatedAt int
+	UpdatedAt int
+}
+
+// AlertEvent is a change of the state of an alert rule. Expected is the
+// threshold or baseline the value was held against.
+type AlertEvent struct {
+	ID       int     `gorm:"primaryKey" json:"id"`
+	RuleID   int     `gorm:"index;not null" json:"rule_id"`
+	Aid      int     `gorm:"index;not null" json:"aid"`
+	State    string  `gorm:"size:16;not null" json:"state"`
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"`
+	Notified bool    `json:"notified"`
+	Error    string  `gorm:"size:255" json:"error"`
+
+	CreatedAt int `json:"created_at"`
+}
@@ -9,12 +9,30 @@
 
 package model
 
+import "encoding/json"
+
 type App struct {
 	Aid         int    `gorm:"primaryKey;autoIncrement" json:"aid" form:"aid"`
 	Icon        string `gorm:"size:255;not null" json:"icon" form:"icon"`
 	Name        string `gorm:"size:32;not null" json:"name" form:"name"`
 	Description string `gorm:"size:255;" json:"des" form:"des"`
 	Activated   bool   `gorm:"bool;default:false" json:"activated" form:"activated"`
-	CreatedAt   int
-	UpdatedAt   int
+	SchemaMode  string `gorm:"size:16" json:"schema_mode" form:"schema_mode"`
+	ClockPolicy string `gorm:"size:16" json:"clock_policy" form:"clock_policy"`
+	EnrichGeo   bool   `gorm:"bool;default:false" json:"enrich_geo" form:"enrich_geo"`
+	EnrichUA    bool   `gorm:"bool;default:false" json:"enrich_ua" form:"enrich_ua"`
+
+	// RedactionSalt keys the hashes of redacted properties, it is never sent out
+	RedactionSalt string `gorm:"size:64" json:"-" form:"-"`
+
+	CreatedAt int
+	UpdatedAt int
+}
+
+func (app App) MarshalBinary() ([]byte, error) {
+	return json.Marshal(app)
+}
+
+func (app *App) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, app)
 }
@@ -0,0 +1,43 @@
+//
+// File: deadletter.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	DEADLETTER_STATUS_PENDING   = "pending"
+	DEADLETTER_STATUS_REPLAYED  = "replayed"
+	DEADLETTER_STATUS_DISCARDED = "discarded"
+)
+
+const (
+	DEADLETTER_STAGE_BIND   = "bind"
+	DEADLETTER_STAGE_AID    = "aid"
+	DEADLETTER_STAGE_DATA   = "data"
+	DEADLETTER_STAGE_SCHEMA = "schema"
+)
+
+// DeadLetter is a reported event that was rejected by the ingestion path,
+// kept with its raw body so that it can be fixed and replayed. Bodies in a
+// binary ContentType are kept base64 encoded.
+type DeadLetter struct {
+	ID          int    `gorm:"primaryKey" json:"id" form:"id"`
+	Aid         int    `gorm:"index;not null" json:"aid" form:"aid"`
+	Ak          string `gorm:"size:64" json:"ak" form:"ak"`
+	Stage       string `gorm:"size:16;not null" json:"stage" form:"stage"`
+	Reason      string `gorm:"type:text" json:"reason" form:"reason"`
+	Body        string `gorm:"type:mediumtext" json:"body" form:"body"`
+	ContentType string `gorm:"size:64" json:"content_type" form:"content_type"`
+	ClientIP    string `gorm:"size:64" json:"client_ip" form:"client_ip"`
+	UserAgent   string `gorm:"size:255" json:"user_agent" form:"user_agent"`
+	SignedAt    int64  `json:"signed_at" form:"signed_at"`
+	Status      string `gorm:"size:16;index;default:pending" json:"status" form:"status"`
+	Replays     int    `gorm:"default:0" json:"replays" form:"replays"`
+	CreatedAt   int    `json:"created_at"`
+	UpdatedAt   int    `json:"updated_at"`
+}
@@ -9,12 +9,163 @@
 
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CLOCK_POLICY_FLAG  = "flag"
+	CLOCK_POLICY_CLAMP = "clamp"
+)
+
+// DeviceID identifies a device of an app. Older SDKs report it as a number,
+// which is kept as its decimal form. Those SDKs report 0 for no device, so a
+// zero did is the empty DeviceID.
+type DeviceID string
+
+// NumericDeviceID returns the DeviceID of a did reported as a number.
+func NumericDeviceID(n json.Number) DeviceID {
+	if f, err := n.Float64(); err == nil && f == 0 {
+		return ""
+	}
+
+	return DeviceID(n.String())
+}
+
+var ErrInvalidDeviceID = errors.New("did must be a string or a number")
+
+func (did *DeviceID) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*did = ""
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		*did = DeviceID(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return ErrInvalidDeviceID
+	}
+
+	*did = NumericDeviceID(n)
+	return nil
+}
 
 type Event struct {
 	Aid   int       `json:"aid" form:"aid"`
-	Did   int       `json:"did" form:"did"`
+	Did   DeviceID  `json:"did" form:"did"`
 	Event string    `json:"event" form:"event"`
 	Data  string    `json:"data" form:"data"`
 	Time  time.Time `json:"time" form:"time"`
+
+	// UserID is the known user of the device, when the app has one
+	UserID string `json:"user_id,omitempty" form:"user_id"`
+
+	// ClientTime is when the event happened by the device clock, in unix
+	// milliseconds. Time is ClientTime corrected by ClockSkew, or ServerTime
+	// when the device did not report it.
+	ClientTime int64     `json:"client_time,omitempty" form:"client_time"`
+	ServerTime time.Time `json:"server_time" form:"-"`
+	ClockSkew  int64     `json:"clock_skew,omitempty" form:"-"`
+
+	// EventID is an optional client generated id used to drop retried reports
+	EventID string `json:"event_id,omitempty" form:"event_id"`
+
+	// SessionID is the session of the device the event was assigned to
+	SessionID string `json:"session_id,omitempty" form:"-"`
+
+	Geo *GeoInfo       `json:"geo,omitempty" form:"-"`
+	UA  *UserAgentInfo `json:"ua,omitempty" form:"-"`
+
+	SchemaVersion int      `json:"schema_version,omitempty" form:"-"`
+	Tags          []string `json:"tags,omitempty" form:"-"`
+
+	// SampleRate is the rate the event was kept at by the sampling rules of
+	// its app. Events that are not sampled leave it out.
+	SampleRate float64 `json:"sample_rate,omitempty" form:"-"`
+}
+
+// LazyData parses the Data of an event the first time it is needed.
+func (e *Event) LazyData() func() map[string]interface{} {
+	var data map[string]interface{}
+	parsed := false
+	return func() map[string]interface{} {
+		if !parsed {
+			parsed = true
+			// data was checked at ingestion, a failure only means no property matches
+			json.Unmarshal([]byte(e.Data), &data)
+		}
+		return data
+	}
+}
+
+// DataProperty returns the Data property at a dot separated path in its string form.
+func DataProperty(data map[string]interface{}, path string) (string, bool) {
+	var v interface{} = data
+	for _, seg := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		if v, ok = m[seg]; !ok {
+			return "", false
+		}
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case nil:
+		return "", false
+	}
+
+	jv, err := json.Marshal(v)
+	return string(jv), err == nil
+}
+
+// GeoInfo is where the client ip of an event is located.
+type GeoInfo struct {
+	Continent string `json:"continent,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Region    string `json:"region,omitempty"`
+	City      string `json:"city,omitempty"`
+}
+
+// UserAgentInfo is the parsed User-Agent header of the request an event came in.
+type UserAgentInfo struct {
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"os_version,omitempty"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	DeviceType     string `json:"device_type,omitempty"`
+}
+
+// EventRow is how an event is kept in a mysql table. Payload is the event as
+// it was emitted, so fields added to Event later are not lost.
+type EventRow struct {
+	ID      int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Aid     int       `gorm:"index:idx_event_row_aid_time;not null" json:"aid"`
+	Did     string    `gorm:"size:128;index" json:"did"`
+	UserID  string    `gorm:"size:128;index" json:"user_id"`
+	Event   string    `gorm:"size:128;index" json:"event"`
+	Data    string    `gorm:"type:text" json:"data"`
+	Time    time.Time `gorm:"index:idx_event_row_aid_time" json:"time"`
+	Payload string    `gorm:"type:text" json:"payload"`
 }
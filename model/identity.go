@@ -0,0 +1,28 @@
+//
+// File: identity.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	IDENTITY_SOURCE_IDENTIFY = "identify"
+	IDENTITY_SOURCE_ALIAS    = "alias"
+)
+
+// Identity links a device of an app to a known user. A device may be linked to
+// several users and a user to several devices. Source tells whether the link
+// was reported by an identify call or taken over from another user by alias.
+type Identity struct {
+	ID        int    `gorm:"primaryKey" json:"id"`
+	Aid       int    `gorm:"uniqueIndex:idx_identity_link;index:idx_identity_user,priority:1;not null" json:"aid"`
+	Did       string `gorm:"size:128;uniqueIndex:idx_identity_link;not null" json:"did"`
+	UserID    string `gorm:"size:128;uniqueIndex:idx_identity_link;index:idx_identity_user,priority:2;not null" json:"user_id"`
+	Source    string `gorm:"size:16" json:"source"`
+	CreatedAt int    `json:"created_at"`
+	UpdatedAt int    `json:"updated_at"`
+}
@@ -0,0 +1,37 @@
+//
+// File: limit.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+import "encoding/json"
+
+// AppLimit holds the ingestion limits of an app. Rates are events per second
+// and bursts are the bucket sizes, a burst of zero holds one second of events.
+// Quotas are events per day and month. Zero leaves a limit off.
+type AppLimit struct {
+	Aid          int     `gorm:"primaryKey;autoIncrement:false" json:"aid" form:"aid"`
+	AidRate      float64 `gorm:"default:0" json:"aid_rate" form:"aid_rate"`
+	AidBurst     int     `gorm:"default:0" json:"aid_burst" form:"aid_burst"`
+	AkRate       float64 `gorm:"default:0" json:"ak_rate" form:"ak_rate"`
+	AkBurst      int     `gorm:"default:0" json:"ak_burst" form:"ak_burst"`
+	DidRate      float64 `gorm:"default:0" json:"did_rate" form:"did_rate"`
+	DidBurst     int     `gorm:"default:0" json:"did_burst" form:"did_burst"`
+	DailyQuota   int64   `gorm:"default:0" json:"daily_quota" form:"daily_quota"`
+	MonthlyQuota int64   `gorm:"default:0" json:"monthly_quota" form:"monthly_quota"`
+	CreatedAt    int     `json:"created_at"`
+	UpdatedAt    int     `json:"updated_at"`
+}
+
+func (limit AppLimit) MarshalBinary() ([]byte, error) {
+	return json.Marshal(limit)
+}
+
+func (limit *AppLimit) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, limit)
+}
@@ -0,0 +1,27 @@
+//
+// File: profile.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	PROFILE_KIND_USER   = "user"
+	PROFILE_KIND_DEVICE = "device"
+)
+
+// Profile is the merged properties of a user or a device of an app. Key is the
+// user id for user profiles and the did for device profiles.
+type Profile struct {
+	ID         int    `gorm:"primaryKey" json:"id"`
+	Aid        int    `gorm:"uniqueIndex:idx_profile_key;not null" json:"aid"`
+	Kind       string `gorm:"size:16;uniqueIndex:idx_profile_key;not null" json:"kind"`
+	Key        string `gorm:"column:profile_key;size:128;uniqueIndex:idx_profile_key;not null" json:"key"`
+	Properties string `gorm:"type:text" json:"properties"`
+	CreatedAt  int    `json:"created_at"`
+	UpdatedAt  int    `json:"updated_at"`
+}
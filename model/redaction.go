@@ -0,0 +1,39 @@
+//
+// File: redaction.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	REDACT_ACTION_DROP = "drop"
+	REDACT_ACTION_HASH = "hash"
+	REDACT_ACTION_MASK = "mask"
+)
+
+const (
+	REDACT_DETECTOR_EMAIL = "email"
+	REDACT_DETECTOR_PHONE = "phone"
+	REDACT_DETECTOR_CARD  = "card"
+	REDACT_DETECTOR_TOKEN = "token"
+)
+
+// RedactionRule removes personal data from Event.Data before an event leaves
+// the proxy. Path selects properties, as dot separated keys where * matches
+// any key or array element, e.g. user.email or items.*.card. Detector selects
+// the strings holding a kind of personal data, only below Path when both are
+// set. Action is applied to whatever was selected.
+type RedactionRule struct {
+	ID          int    `gorm:"primaryKey" json:"id" form:"id"`
+	Aid         int    `gorm:"index;not null" json:"aid" form:"aid"`
+	Path        string `gorm:"size:255" json:"path" form:"path"`
+	Detector    string `gorm:"size:16" json:"detector" form:"detector"`
+	Action      string `gorm:"size:16;not null" json:"action" form:"action"`
+	Description string `gorm:"size:255" json:"des" form:"des"`
+	CreatedAt   int
+	UpdatedAt   int
+}
@@ -0,0 +1,26 @@
+//
+// File: sampling.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+// SamplingRule keeps Rate of the events of an app whose name matches Pattern,
+// a glob as understood by path.Match. With ByDevice a device is either always
+// or never kept, so that kept devices have complete event streams. Of the
+// rules matching an event the one with the highest Priority applies.
+type SamplingRule struct {
+	ID          int     `gorm:"primaryKey" json:"id" form:"id"`
+	Aid         int     `gorm:"index;not null" json:"aid" form:"aid"`
+	Pattern     string  `gorm:"size:128;not null" json:"pattern" form:"pattern"`
+	Rate        float64 `gorm:"not null" json:"rate" form:"rate"`
+	ByDevice    bool    `gorm:"bool;default:false" json:"by_device" form:"by_device"`
+	Priority    int     `gorm:"default:0" json:"priority" form:"priority"`
+	Description string  `gorm:"size:255" json:"des" form:"des"`
+	CreatedAt   int
+	UpdatedAt   int
+}
@@ -0,0 +1,36 @@
+//
+// File: schema.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+const (
+	SCHEMA_STATUS_ACTIVE     = "active"
+	SCHEMA_STATUS_DEPRECATED = "deprecated"
+)
+
+const (
+	SCHEMA_MODE_REJECT = "reject"
+	SCHEMA_MODE_WARN   = "warn"
+	SCHEMA_MODE_TAG    = "tag"
+)
+
+// EventSchema describes one event an app is allowed to report. Schema is a json
+// schema document that Event.Data must satisfy.
+type EventSchema struct {
+	ID          int      `gorm:"primaryKey" json:"id" form:"id"`
+	Aid         int      `gorm:"uniqueIndex:idx_schema_aid_event;not null" json:"aid" form:"aid"`
+	Event       string   `gorm:"uniqueIndex:idx_schema_aid_event;size:128;not null" json:"event" form:"event"`
+	Schema      string   `gorm:"type:text" json:"schema" form:"schema"`
+	Required    []string `gorm:"serializer:json;type:text" json:"required" form:"required"`
+	Status      string   `gorm:"size:16;default:active" json:"status" form:"status"`
+	Version     int      `gorm:"default:1" json:"version" form:"version"`
+	Description string   `gorm:"size:255" json:"des" form:"des"`
+	CreatedAt   int
+	UpdatedAt   int
+}
@@ -0,0 +1,26 @@
+//
+// File: store.go
+// Created by Dizzrt on 2026/10/18.
+//
+// Copyright (C) 2023 The oset Authors.
+// This source code is licensed under the MIT license found in
+// the LICENSE file in the root directory of this source tree.
+//
+
+package model
+
+import "time"
+
+// StoredEvent is a row of the built-in event store. Data is kept as a json
+// column so that queries can filter on its properties, Payload is the event
+// as it was emitted.
+type StoredEvent struct {
+	ID      int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	Aid     int       `gorm:"column:aid"`
+	Did     string    `gorm:"column:did"`
+	UserID  string    `gorm:"column:user_id"`
+	Event   string    `gorm:"column:event"`
+	Time    time.Time `gorm:"column:time;primaryKey"`
+	Data    string    `gorm:"column:data"`
+	Payload string    `gorm:"column:payload"`
+}
@@ -50,9 +50,55 @@ func CollectRoutes(r *gin.Engine) *gin.Engine {
 	appRoutes.POST("aksk/generate", controller.GenerateAKSK)
 	appRoutes.POST("aksk/update", controller.UpdateAksk)
 	appRoutes.DELETE("aksk/delete", controller.DropAKSK)
+	appRoutes.GET("active", controller.GetActiveDevices)
+	appRoutes.GET("alert/list", controller.GetAlertRuleList)
+	appRoutes.POST("alert/create", controller.CreateAlertRule)
+	appRoutes.POST("alert/update", controller.UpdateAlertRule)
+	appRoutes.DELETE("alert/delete", controller.DropAlertRule)
+	appRoutes.GET("alert/history", controller.GetAlertEventList)
+	appRoutes.GET("identity/list", controller.GetIdentityList)
+	appRoutes.GET("limit/info", controller.GetAppLimit)
+	appRoutes.POST("limit/update", controller.UpdateAppLimit)
+	appRoutes.GET("redaction/list", controller.GetRedactionRuleList)
+	appRoutes.POST("redaction/create", controller.CreateRedactionRule)
+	appRoutes.POST("redaction/update", controller.UpdateRedactionRule)
+	appRoutes.DELETE("redaction/delete", controller.DropRedactionRule)
+	appRoutes.GET("profile/info", controller.GetProfile)
+	appRoutes.GET("sampling/list", controller.GetSamplingRuleList)
+	appRoutes.POST("sampling/create", controller.CreateSamplingRule)
+	appRoutes.POST("sampling/update", controller.UpdateSamplingRule)
+	appRoutes.DELETE("sampling/delete", controller.DropSamplingRule)
+	appRoutes.GET("schema/list", controller.GetEventSchemaList)
+	appRoutes.GET("schema/info", controller.GetEventSchema)
+	appRoutes.POST("schema/create", controller.CreateEventSchema)
+	appRoutes.POST("schema/update", controller.UpdateEventSchema)
+	appRoutes.DELETE("schema/delete", controller.DropEventSchema)
+
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(middleware.JwtMiddleware())
+	adminRoutes.GET("deadletter/list", controller.GetDeadLetterList)
+	adminRoutes.POST("deadletter/update", controller.UpdateDeadLetter)
+	adminRoutes.POST("deadletter/replay", controller.ReplayDeadLetter)
+	adminRoutes.POST("deadletter/discard", controller.DiscardDeadLetter)
+	adminRoutes.GET("spool", controller.GetSpoolStats)
+
+	r.GET("/stats", middleware.JwtMiddleware(), controller.GetStats)
+
+	analysisRoutes := r.Group("/analysis")
+	analysisRoutes.Use(middleware.JwtMiddleware())
+	analysisRoutes.POST("funnel", controller.GetFunnel)
+	analysisRoutes.POST("path", controller.GetPath)
+	analysisRoutes.POST("retention", controller.GetRetention)
 
 	eventRoutes := r.Group("/event")
-	eventRoutes.POST("report/:aid", middleware.AkskMiddleware(), controller.ReportEvent)
+	eventRoutes.POST("report/:aid", middleware.AkskMiddleware(), middleware.DecompressMiddleware(), controller.ReportEvent)
+	eventRoutes.POST("batch/:aid", middleware.AkskMiddleware(), middleware.DecompressMiddleware(), controller.ReportEventBatch)
+	eventRoutes.POST("identify/:aid", middleware.AkskMiddleware(), middleware.DecompressMiddleware(), controller.Identify)
+	eventRoutes.POST("alias/:aid", middleware.AkskMiddleware(), middleware.DecompressMiddleware(), controller.Alias)
+	eventRoutes.POST("profile/:aid", middleware.AkskMiddleware(), middleware.DecompressMiddleware(), controller.UpdateProfile)
+	eventRoutes.POST("query", middleware.JwtMiddleware(), controller.QueryEvents)
+	eventRoutes.StaticFile("proto", "./proto/event.proto")
 	eventRoutes.GET("tool/realtime/:aid/:did", controller.RegisterRealtimeEvent)
+	eventRoutes.GET("tool/active/:aid", controller.RegisterActiveStream)
 	return r
 }